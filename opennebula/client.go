@@ -7,11 +7,33 @@ import (
 	"strconv"
 )
 
+// Error codes returned by OpenNebula along with a failed response
+const (
+	ErrAuthentication = 0x0100
+	ErrAuthorization  = 0x0200
+	ErrNoExists       = 0x0400
+	ErrAction         = 0x0800
+	ErrXmlRpcApi      = 0x1000
+	ErrInternal       = 0x2000
+)
+
+// Error is returned by Call when OpenNebula reports a failure, keeping the
+// error code so callers can tell missing objects apart from other problems.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
 type Client struct {
-	Rcp      xmlrpc.Client
-	session  string
-	Username string
-	Password string
+	Rcp            xmlrpc.Client
+	session        string
+	Username       string
+	Password       string
+	UploadEndpoint string
 }
 
 func NewClient(endpoint, username, password string) (*Client, error) {
@@ -47,7 +69,13 @@ func (c *Client) Call(command string, args ...interface{}) (string, error) {
 
 func (c *Client) IsSuccess(result []interface{}) (res string, err error) {
 	if !result[0].(bool) {
-		err = fmt.Errorf("%s", result[1].(string))
+		oneErr := &Error{Message: fmt.Sprintf("%s", result[1].(string))}
+		if len(result) > 2 {
+			if code, ok := result[2].(int64); ok {
+				oneErr.Code = int(code)
+			}
+		}
+		err = oneErr
 		return
 	}
 
@@ -68,3 +96,13 @@ func intId(id string) int {
 
 	return i
 }
+
+// isNotFound tells whether an error returned by Call means that the requested
+// object does not exist (anymore).
+func isNotFound(err error) bool {
+	if oneErr, ok := err.(*Error); ok {
+		return oneErr.Code == ErrNoExists
+	}
+
+	return false
+}
@@ -0,0 +1,43 @@
+package opennebula
+
+import (
+	"log"
+)
+
+// changeClusters moves an object from the clusters in old to the ones in new. kind is the kind
+// of object as named by the cluster API (vnet, datastore or host), e.g. one.cluster.addvnet.
+// The object is added to its new clusters before being removed from the old ones, so it isn't
+// left out of every cluster halfway
+func changeClusters(client *Client, kind string, id int, old, new []interface{}) error {
+	for _, c := range new {
+		if containsInt(old, c.(int)) {
+			continue
+		}
+		if _, err := client.Call("one.cluster.add"+kind, c.(int), id); err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully added %s %d to cluster %d\n", kind, id, c.(int))
+	}
+
+	for _, c := range old {
+		if containsInt(new, c.(int)) {
+			continue
+		}
+		if _, err := client.Call("one.cluster.del"+kind, c.(int), id); err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully removed %s %d from cluster %d\n", kind, id, c.(int))
+	}
+
+	return nil
+}
+
+func containsInt(values []interface{}, i int) bool {
+	for _, v := range values {
+		if v.(int) == i {
+			return true
+		}
+	}
+
+	return false
+}
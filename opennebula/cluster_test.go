@@ -0,0 +1,23 @@
+package opennebula
+
+import (
+	"testing"
+)
+
+func TestChangeClusters(t *testing.T) {
+	client, standIn := newTestClient(t, map[string]func([]string) (interface{}, error){
+		"one.cluster.addvnet": func(params []string) (interface{}, error) { return 4, nil },
+		"one.cluster.delvnet": func(params []string) (interface{}, error) { return 4, nil },
+	})
+	defer standIn.Close()
+
+	if err := changeClusters(client, "vnet", 4, []interface{}{0, 100}, []interface{}{100, 101}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []xmlRpcCall{
+		{Method: "one.cluster.addvnet", Params: []string{"101", "4"}},
+		{Method: "one.cluster.delvnet", Params: []string{"0", "4"}},
+	}
+	standIn.checkCalls(t, expected)
+}
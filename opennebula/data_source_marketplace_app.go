@@ -0,0 +1,130 @@
+package opennebula
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func dataSourceMarketApp() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourceMarketAppRead,
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Name of the marketplace app",
+			},
+			"market_id": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     -1,
+				Description: "ID of the marketplace to look the app up in. By default, all marketplaces are searched",
+			},
+			"market": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the marketplace the app is published in",
+			},
+			"uid": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the user that owns the marketplace app",
+			},
+			"gid": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the group that owns the marketplace app",
+			},
+			"uname": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the user that owns the marketplace app",
+			},
+			"gname": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the group that owns the marketplace app",
+			},
+			"type": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Type of the app: IMAGE, VMTEMPLATE or SERVICE_TEMPLATE",
+			},
+			"state": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Current state of the app, e.g. READY",
+			},
+			"size": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Size of the app in MB",
+			},
+			"md5": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "MD5 checksum of the app contents",
+			},
+			"source": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Location of the app contents within the marketplace",
+			},
+			"reg_time": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Registration time",
+			},
+			"description": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Description of the app, as shown in the marketplace",
+			},
+			"version": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Version of the app",
+			},
+		},
+	}
+}
+
+func dataSourceMarketAppRead(d *schema.ResourceData, meta interface{}) error {
+	var apps *MarketApps
+	var app *MarketApp
+
+	client := meta.(*Client)
+	name := d.Get("name").(string)
+	marketId := d.Get("market_id").(int)
+
+	resp, err := client.Call("one.marketapppool.info", -2, -1, -1)
+	if err != nil {
+		return err
+	}
+
+	if err = xml.Unmarshal([]byte(resp), &apps); err != nil {
+		return err
+	}
+
+	for _, a := range apps.MarketApp {
+		if a.Name != name || (marketId >= 0 && a.MarketId != marketId) {
+			continue
+		}
+		if app != nil {
+			return fmt.Errorf("Found more than one marketplace app with name %s (in %s and %s), set market_id", name, app.Market, a.Market)
+		}
+		app = a
+	}
+
+	if app == nil {
+		return fmt.Errorf("Could not find marketplace app with name %s", name)
+	}
+
+	setMarketAppAttributes(d, app)
+	d.Set("description", app.Description)
+	d.Set("version", app.Version)
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/schema"
+	"math/big"
+	"net"
+)
+
+// normalizeIP returns the 4 byte form of IPv4 addresses and the 16 byte form of IPv6 ones
+func normalizeIP(ip net.IP) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+
+	return ip.To16()
+}
+
+// parseIP parses an IPv4 or IPv6 address, failing on anything else
+func parseIP(s string) (net.IP, error) {
+	ip := normalizeIP(net.ParseIP(s))
+	if ip == nil {
+		return nil, fmt.Errorf("%q is not a valid IP address", s)
+	}
+
+	return ip, nil
+}
+
+// ipAdd returns the address n positions after ip, carrying across octets.
+// It fails if the result falls outside the address family of ip
+func ipAdd(ip net.IP, n int) (net.IP, error) {
+	ip = normalizeIP(ip)
+	if ip == nil {
+		return nil, fmt.Errorf("Invalid IP address")
+	}
+
+	sum := new(big.Int).SetBytes(ip)
+	sum.Add(sum, big.NewInt(int64(n)))
+	if sum.Sign() < 0 || sum.BitLen() > len(ip)*8 {
+		return nil, fmt.Errorf("%s + %d is out of the address space", ip, n)
+	}
+
+	result := make(net.IP, len(ip))
+	bytes := sum.Bytes()
+	copy(result[len(result)-len(bytes):], bytes)
+
+	return result, nil
+}
+
+// ipRangeContains tells whether the count addresses from start fall within the size addresses from first
+func ipRangeContains(first net.IP, size int, start net.IP, count int) bool {
+	first, start = normalizeIP(first), normalizeIP(start)
+	if first == nil || start == nil || len(first) != len(start) || count < 0 {
+		return false
+	}
+
+	offset := new(big.Int).Sub(new(big.Int).SetBytes(start), new(big.Int).SetBytes(first))
+	end := new(big.Int).Add(offset, big.NewInt(int64(count)))
+
+	return offset.Sign() >= 0 && end.Cmp(big.NewInt(int64(size))) <= 0
+}
+
+// ipLeaseAttribute is the attribute OpenNebula identifies leases of the address family of ip by
+func ipLeaseAttribute(ip net.IP) string {
+	if ip.To4() != nil {
+		return "IP"
+	}
+
+	return "IP6"
+}
+
+func validateIP(v interface{}, k string) (ws []string, errors []error) {
+	if _, err := parseIP(v.(string)); err != nil {
+		errors = append(errors, fmt.Errorf("%q: %s", k, err))
+	}
+
+	return
+}
+
+// suppressEquivalentIPs ignores changes to an address field that only change how the address is written
+func suppressEquivalentIPs(k, old, new string, d *schema.ResourceData) bool {
+	return sameIP(old, new)
+}
@@ -0,0 +1,95 @@
+package opennebula
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIpAdd(t *testing.T) {
+	cases := []struct {
+		ip       string
+		n        int
+		expected string
+	}{
+		{"192.168.0.1", 1, "192.168.0.2"},
+		{"192.168.0.255", 1, "192.168.1.0"},
+		{"192.168.0.200", 300, "192.168.1.244"},
+		{"10.255.255.255", 1, "11.0.0.0"},
+		{"192.168.1.0", -1, "192.168.0.255"},
+		{"2001:db8::ffff", 1, "2001:db8::1:0"},
+		{"2001:db8:0:0:ffff:ffff:ffff:ffff", 2, "2001:db8:0:1::1"},
+	}
+
+	for _, c := range cases {
+		ip, err := ipAdd(net.ParseIP(c.ip), c.n)
+		if err != nil {
+			t.Errorf("%s + %d: %s", c.ip, c.n, err)
+			continue
+		}
+		if ip.String() != c.expected {
+			t.Errorf("Expected %s + %d to be %s, got %s", c.ip, c.n, c.expected, ip)
+		}
+	}
+
+	for _, ip := range []string{"255.255.255.255", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"} {
+		if result, err := ipAdd(net.ParseIP(ip), 1); err == nil {
+			t.Errorf("Expected %s + 1 to overflow, got %s", ip, result)
+		}
+	}
+	if result, err := ipAdd(net.ParseIP("0.0.0.0"), -1); err == nil {
+		t.Errorf("Expected 0.0.0.0 - 1 to underflow, got %s", result)
+	}
+}
+
+func TestIpRangeContains(t *testing.T) {
+	cases := []struct {
+		first    string
+		size     int
+		start    string
+		count    int
+		expected bool
+	}{
+		{"192.168.0.250", 10, "192.168.0.250", 10, true},
+		{"192.168.0.250", 10, "192.168.1.0", 4, true},
+		{"192.168.0.250", 10, "192.168.1.0", 5, false},
+		{"192.168.0.250", 10, "192.168.0.249", 1, false},
+		{"2001:db8::fffe", 4, "2001:db8::1:0", 2, true},
+		{"2001:db8::fffe", 4, "2001:db8::1:1", 2, false},
+		{"192.168.0.1", 10, "2001:db8::1", 1, false},
+	}
+
+	for _, c := range cases {
+		if result := ipRangeContains(net.ParseIP(c.first), c.size, net.ParseIP(c.start), c.count); result != c.expected {
+			t.Errorf("Expected %d addresses from %s within %d from %s to be %t", c.count, c.start, c.size, c.first, c.expected)
+		}
+	}
+}
+
+func TestIpLeaseAttribute(t *testing.T) {
+	if a := ipLeaseAttribute(net.ParseIP("10.0.0.1")); a != "IP" {
+		t.Errorf("Expected IPv4 leases to use IP, got %s", a)
+	}
+	if a := ipLeaseAttribute(net.ParseIP("2001:db8::1")); a != "IP6" {
+		t.Errorf("Expected IPv6 leases to use IP6, got %s", a)
+	}
+}
+
+func TestSuppressEquivalentIPs(t *testing.T) {
+	cases := []struct {
+		old      string
+		new      string
+		expected bool
+	}{
+		{"2001:db8::1", "2001:0db8:0000::0001", true},
+		{"2001:db8::1", "2001:DB8::1", true},
+		{"10.0.0.1", "::ffff:10.0.0.1", true},
+		{"10.0.0.1", "10.0.0.2", false},
+		{"", "10.0.0.1", false},
+	}
+
+	for _, c := range cases {
+		if result := suppressEquivalentIPs("ip", c.old, c.new, nil); result != c.expected {
+			t.Errorf("Expected a change from %q to %q to be suppressed: %t", c.old, c.new, c.expected)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/schema"
+	"log"
+)
+
+type Lock struct {
+	Locked int `xml:"LOCKED"`
+	Owner  int `xml:"OWNER"`
+	Time   int `xml:"TIME"`
+}
+
+// Lock levels, each one blocking the operations of the previous one as well
+var lockLevels = []string{"", "USE", "MANAGE", "ADMIN", "ALL"}
+
+func lockLevelName(l *Lock) string {
+	if l == nil || l.Locked <= 0 || l.Locked >= len(lockLevels) {
+		return ""
+	}
+
+	return lockLevels[l.Locked]
+}
+
+func lockLevel(name string) int {
+	for i, l := range lockLevels {
+		if l == name {
+			return i
+		}
+	}
+
+	return 0
+}
+
+// changeLock moves the lock of an object from one level to another, where an empty level means unlocked.
+// prefix is the API namespace of the object, e.g. "one.vm"
+func changeLock(id int, from, level string, client *Client, prefix string) error {
+	if from != "" {
+		if _, err := client.Call(prefix+".unlock", id); err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully unlocked %s %d\n", prefix, id)
+	}
+
+	if level == "" {
+		return nil
+	}
+
+	// test = false: lock the object even if it is already locked by someone else
+	if _, err := client.Call(prefix+".lock", id, lockLevel(level), false); err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Successfully locked %s %d at level %s\n", prefix, id, level)
+	return nil
+}
+
+// unlockForUpdate lifts the lock an object had before an update, so that it doesn't block the rest of the changes.
+// prefix is the API namespace of the object, e.g. "one.vm". Defer relockAfterUpdate right after it
+func unlockForUpdate(d *schema.ResourceData, client *Client, prefix string) error {
+	if !d.HasChange("lock") {
+		return nil
+	}
+
+	old, _ := d.GetChange("lock")
+	return changeLock(intId(d.Id()), old.(string), "", client, prefix)
+}
+
+// relockAfterUpdate locks an object at its new level once the update that unlockForUpdate started succeeded,
+// or puts the previous lock back if it failed with *err
+func relockAfterUpdate(d *schema.ResourceData, client *Client, prefix string, err *error) {
+	if !d.HasChange("lock") {
+		return
+	}
+
+	old, new := d.GetChange("lock")
+	if *err != nil {
+		restoreLock(err, intId(d.Id()), old.(string), client, prefix)
+		return
+	}
+
+	*err = changeLock(intId(d.Id()), "", new.(string), client, prefix)
+}
+
+// restoreLock puts the lock of an object back at level if the update that lifted it failed with *err, so that
+// the object isn't left unlocked
+func restoreLock(err *error, id int, level string, client *Client, prefix string) {
+	if *err == nil || level == "" {
+		return
+	}
+
+	if lockErr := changeLock(id, "", level, client, prefix); lockErr != nil {
+		log.Printf("[WARN] Could not put the lock of %s %d back at level %s: %s\n", prefix, id, level, lockErr)
+	}
+}
+
+// checkUnlocked refuses to go ahead with destructive operations on locked objects
+func checkUnlocked(kind, id, level string) error {
+	if level != "" {
+		return fmt.Errorf("%s %s is locked (level %s). Remove the lock before destroying it", kind, id, level)
+	}
+
+	return nil
+}
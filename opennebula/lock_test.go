@@ -0,0 +1,32 @@
+package opennebula
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRestoreLock(t *testing.T) {
+	client, standIn := newTestClient(t, map[string]func([]string) (interface{}, error){
+		"one.vn.lock": func(params []string) (interface{}, error) { return 3, nil },
+	})
+	defer standIn.Close()
+
+	// successful updates and objects that weren't locked are left alone
+	var updateErr error
+	restoreLock(&updateErr, 3, "MANAGE", client, "one.vn")
+	updateErr = fmt.Errorf("update failed")
+	restoreLock(&updateErr, 3, "", client, "one.vn")
+	if len(standIn.Calls) != 0 {
+		t.Errorf("Expected no calls, got %v", standIn.Calls)
+	}
+
+	restoreLock(&updateErr, 3, "MANAGE", client, "one.vn")
+
+	expected := []xmlRpcCall{
+		{Method: "one.vn.lock", Params: []string{"3", "2", "0"}},
+	}
+	standIn.checkCalls(t, expected)
+	if updateErr == nil || updateErr.Error() != "update failed" {
+		t.Errorf("Expected the error of the update to be kept, got %v", updateErr)
+	}
+}
@@ -0,0 +1,31 @@
+package opennebula
+
+import (
+	"github.com/hashicorp/terraform/helper/schema"
+	"log"
+)
+
+// changeOwnership hands an object over to the user and group set in uid and gid, if any.
+// method is the chown call of the object, e.g. "one.market.chown"
+func changeOwnership(d *schema.ResourceData, client *Client, method string) error {
+	// GetOk would take the IDs of oneadmin and its group, 0, for unset ones
+	uid, gid := -1, -1
+	if v, ok := d.GetOkExists("uid"); ok {
+		uid = v.(int)
+	}
+	if v, ok := d.GetOkExists("gid"); ok {
+		gid = v.(int)
+	}
+
+	if uid < 0 && gid < 0 {
+		return nil
+	}
+
+	// -1 keeps the current user or group
+	if _, err := client.Call(method, intId(d.Id()), uid, gid); err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Successfully called %s on %s\n", method, d.Id())
+	return nil
+}
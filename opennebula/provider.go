@@ -26,13 +26,31 @@ func Provider() terraform.ResourceProvider {
 				Description: "The password for the user",
 				DefaultFunc: schema.EnvDefaultFunc("OPENNEBULA_PASSWORD", nil),
 			},
+			"upload_endpoint": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The URL of the Sunstone/FireEdge endpoint that local Image files are uploaded to",
+				DefaultFunc: schema.EnvDefaultFunc("OPENNEBULA_UPLOAD_ENDPOINT", ""),
+			},
 		},
 
 		ResourcesMap: map[string]*schema.Resource{
-			"opennebula_template": resourceTemplate(),
-			"opennebula_vnet":     resourceVnet(),
-			"opennebula_vm":       resourceVm(),
-			"opennebula_image":    resourceImage(),
+			"opennebula_template":              resourceTemplate(),
+			"opennebula_vnet":                  resourceVnet(),
+			"opennebula_vnet_lease_hold":       resourceVnetLeaseHold(),
+			"opennebula_vnet_reservation":      resourceVnetReservation(),
+			"opennebula_vnet_template":         resourceVnTemplate(),
+			"opennebula_vm":                    resourceVm(),
+			"opennebula_image":                 resourceImage(),
+			"opennebula_virtual_machine_group": resourceVmGroup(),
+			"opennebula_marketplace_app":       resourceMarketApp(),
+			"opennebula_marketplace":           resourceMarket(),
+			"opennebula_security_group":        resourceSecurityGroup(),
+			"opennebula_virtual_router":        resourceVirtualRouter(),
+		},
+
+		DataSourcesMap: map[string]*schema.Resource{
+			"opennebula_marketplace_app": dataSourceMarketApp(),
 		},
 
 		ConfigureFunc: providerConfigure,
@@ -40,9 +58,15 @@ func Provider() terraform.ResourceProvider {
 }
 
 func providerConfigure(d *schema.ResourceData) (interface{}, error) {
-	return NewClient(
+	client, err := NewClient(
 		d.Get("endpoint").(string),
 		d.Get("username").(string),
 		d.Get("password").(string),
 	)
+	if err != nil {
+		return nil, err
+	}
+
+	client.UploadEndpoint = d.Get("upload_endpoint").(string)
+	return client, nil
 }
@@ -2,36 +2,50 @@ package opennebula
 
 import (
 	"encoding/xml"
-	"errors"
 	"fmt"
 	"github.com/hashicorp/terraform/helper/resource"
 	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
+	"github.com/runtastic/terraform-provider-opennebula/opennebula/template"
 	"log"
+	"os"
 	"strconv"
 	"strings"
 	"time"
 )
 
 type Image struct {
-	Name        string       `xml:"NAME"`
-	Id          int          `xml:"ID"`
-	Uid         int          `xml:"UID"`
-	Gid         int          `xml:"GID"`
-	Uname       string       `xml:"UNAME"`
-	Gname       string       `xml:"GNAME"`
-	Permissions *Permissions `xml:"PERMISSIONS"`
-	RegTime     string       `xml:"REG"`
-	Size        int          `xml:"SIZE"`
-	State       int          `xml:"STATE"`
-	Source      string       `xml:"SOURCE"`
-	Path        string       `xml:"PATH"`
-	Persistent  string       `xml:"PERSISTENT"`
-	DatastoreID int          `xml:"DATASTORE_ID"`
-	Datastore   string       `xml:"DATASTORE"`
-	FsType      string       `xml:"FSTYPE"`
-	RunningVMs  int          `xml:"RUNNING_VMS"`
+	Name        string         `xml:"NAME"`
+	Id          int            `xml:"ID"`
+	Uid         int            `xml:"UID"`
+	Gid         int            `xml:"GID"`
+	Uname       string         `xml:"UNAME"`
+	Gname       string         `xml:"GNAME"`
+	Permissions *Permissions   `xml:"PERMISSIONS"`
+	Lock        *Lock          `xml:"LOCK"`
+	RegTime     int            `xml:"REGTIME"`
+	Size        int            `xml:"SIZE"`
+	State       int            `xml:"STATE"`
+	Source      string         `xml:"SOURCE"`
+	Path        string         `xml:"PATH"`
+	Persistent  string         `xml:"PERSISTENT"`
+	DatastoreID int            `xml:"DATASTORE_ID"`
+	Datastore   string         `xml:"DATASTORE"`
+	FsType      string         `xml:"FSTYPE"`
+	Type        int            `xml:"TYPE"`
+	RunningVMs  int            `xml:"RUNNING_VMS"`
+	Template    *ImageTemplate `xml:"TEMPLATE"`
 }
 
+type ImageTemplate struct {
+	DevPrefix string `xml:"DEV_PREFIX"`
+	Driver    string `xml:"DRIVER"`
+	Error     string `xml:"ERROR"`
+}
+
+// Image types, indexed by the code OpenNebula uses for them
+var imageTypes = []string{"OS", "CDROM", "DATABLOCK", "KERNEL", "RAMDISK", "CONTEXT"}
+
 type Images struct {
 	Image []*Image `xml:"IMAGE"`
 }
@@ -46,6 +60,10 @@ func resourceImage() *schema.Resource {
 		Importer: &schema.ResourceImporter{
 			State: schema.ImportStatePassthrough,
 		},
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(10 * time.Minute),
+		},
+		CustomizeDiff: resourceImageCustomizeDiff,
 
 		Schema: map[string]*schema.Schema{
 			"name": {
@@ -54,9 +72,11 @@ func resourceImage() *schema.Resource {
 				Description: "Name of the Image",
 			},
 			"description": {
-				Type:        schema.TypeString,
-				Optional:    true,
-				Description: "Description of the Image, in OpenNebula's XML or String format",
+				Type:             schema.TypeString,
+				Optional:         true,
+				Description:      "Description of the Image, in OpenNebula's XML or String format",
+				ValidateFunc:     validateTemplate,
+				DiffSuppressFunc: suppressEquivalentTemplates,
 			},
 			"permissions": {
 				Type:        schema.TypeString,
@@ -104,21 +124,166 @@ func resourceImage() *schema.Resource {
 				Description: "Name of the group that will own the Image",
 			},
 			"clone_from_image": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ForceNew:      true,
+				ConflictsWith: []string{"clone_from_image_id"},
+				Description:   "Name of the Image to be cloned from. If Image Name is empty, a new Image will be created",
+			},
+			"clone_from_image_id": {
+				Type:          schema.TypeInt,
+				Optional:      true,
+				ForceNew:      true,
+				Default:       -1,
+				ConflictsWith: []string{"clone_from_image"},
+				Description:   "ID of the Image to be cloned from",
+			},
+			"marketplace_app_id": {
+				Type:          schema.TypeInt,
+				Optional:      true,
+				ForceNew:      true,
+				Default:       -1,
+				ConflictsWith: []string{"clone_from_image", "clone_from_image_id", "path", "upload_file"},
+				Description:   "ID of the marketplace app the Image is exported from",
+			},
+			"exported_template_id": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the VM template exported along with the marketplace app, or -1",
+			},
+			"clone_from_image_owner": {
 				Type:        schema.TypeString,
 				Optional:    true,
-				Description: "Name of the Image to be cloned from. If Image Name is empty, a new Image will be created",
+				ForceNew:    true,
+				Description: "Name of the user owning the Image given by clone_from_image, if several users have an Image with that name",
 			},
 			"datastore_id": {
 				Type:        schema.TypeInt,
 				Required:    true,
+				ForceNew:    true,
 				Description: "ID of the datastore where Image will be stored",
 			},
+			"datastore": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the datastore where the Image is stored",
+			},
+			"source": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Location of the Image within the datastore",
+			},
+			"running_vms": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Number of VMs using the Image",
+			},
+			"reg_time": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Registration time",
+			},
 			"persistent": {
 				Type:        schema.TypeBool,
 				Optional:    true,
 				Default:     true,
 				Description: "Flag which indicates if the Image has to be persistent",
 			},
+			"enabled": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     true,
+				Description: "Flag which indicates if new VMs can use the Image. VMs that already use it are not affected",
+			},
+			"force_delete": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Delete the Image even if VMs are still using it, which OpenNebula otherwise refuses",
+			},
+			"state": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Current state of the Image",
+			},
+			"state_name": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the current state of the Image, e.g. READY",
+			},
+			"lock": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "Lock level of the Image: USE, MANAGE, ADMIN or ALL. Locked Images can't be destroyed",
+				ValidateFunc: validation.StringInSlice([]string{"USE", "MANAGE", "ADMIN", "ALL"}, false),
+			},
+			"adopt_by_name": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "If the Image can't be found by ID, adopt the only Image of the user with the same name",
+			},
+			"path": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Computed:      true,
+				ForceNew:      true,
+				ConflictsWith: []string{"upload_file"},
+				Description:   "URL (http or https) or path on the OpenNebula frontend of the Image contents",
+				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+					value := v.(string)
+
+					if !strings.HasPrefix(value, "http://") && !strings.HasPrefix(value, "https://") && !strings.HasPrefix(value, "/") {
+						errors = append(errors, fmt.Errorf("%q has to be an http(s) URL or an absolute path, got %q", k, value))
+					}
+
+					return
+				},
+			},
+			"upload_file": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ForceNew:      true,
+				ConflictsWith: []string{"path"},
+				Description:   "Path of a local file that is uploaded to the provider's upload_endpoint and used as the Image contents",
+			},
+			"checksum": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "SHA1 checksum of the uploaded file. The Image is replaced when the local file's checksum changes",
+			},
+			"type": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Computed:     true,
+				Description:  "Type of the Image: OS, CDROM, DATABLOCK, KERNEL, RAMDISK or CONTEXT",
+				ValidateFunc: validation.StringInSlice(imageTypes, false),
+			},
+			"dev_prefix": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				Description: "Prefix of the device the Image is attached as, e.g. vd",
+			},
+			"driver": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				Description: "Driver the hypervisor uses for the Image, e.g. qcow2",
+			},
+			"format": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "Format of the Image on disk, e.g. qcow2 or raw",
+			},
+			"size": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				Description: "Size of the Image in MB. Required for empty DATABLOCK Images",
+			},
 		},
 	}
 }
@@ -127,20 +292,58 @@ func resourceImageCreate(d *schema.ResourceData, meta interface{}) error {
 	client := meta.(*Client)
 
 	// Check if Image ID for cloning is set
-	if len(d.Get("clone_from_image").(string)) > 0 {
+	if len(d.Get("clone_from_image").(string)) > 0 || d.Get("clone_from_image_id").(int) >= 0 {
 		return resourceImageClone(d, meta)
 	}
 
+	if d.Get("marketplace_app_id").(int) >= 0 {
+		return resourceImageExport(d, meta)
+	}
+
+	if d.Get("type").(string) == "DATABLOCK" && d.Get("path").(string) == "" && d.Get("upload_file").(string) == "" && d.Get("size").(int) <= 0 {
+		return fmt.Errorf("Empty DATABLOCK Images need a size")
+	}
+
 	var isPersistent string
 	isPersistent = "NO"
 	if d.Get("persistent").(bool) {
 		isPersistent = "YES"
 	}
 
+	tmpl := template.New().Add("NAME", d.Get("name").(string)).Add("PERSISTENT", isPersistent)
+	for _, attr := range []struct{ key, field string }{
+		{"PATH", "path"},
+		{"TYPE", "type"},
+		{"FORMAT", "format"},
+	} {
+		if v := d.Get(attr.field).(string); v != "" {
+			tmpl.Add(attr.key, v)
+		}
+	}
+	if size := d.Get("size").(int); size > 0 {
+		tmpl.Add("SIZE", size)
+	}
+
+	if file := d.Get("upload_file").(string); file != "" {
+		tmpPath, checksum, err := client.Upload(file)
+		if err != nil {
+			return err
+		}
+
+		// OpenNebula verifies the checksum when it copies the file into the datastore
+		tmpl.Add("PATH", tmpPath).Add("SHA1", checksum)
+		d.Set("checksum", checksum)
+	}
+
+	attrs, err := imageTemplate(d)
+	if err != nil {
+		return err
+	}
+
 	// Create base object
 	resp, err := client.Call(
 		"one.image.allocate",
-		fmt.Sprintf("NAME = \"%s\"\nPERSISTENT = \"%s\"\n", d.Get("name").(string), isPersistent)+d.Get("description").(string),
+		tmpl.Append(attrs.Attributes...).String(),
 		d.Get("datastore_id"),
 	)
 	if err != nil {
@@ -149,9 +352,11 @@ func resourceImageCreate(d *schema.ResourceData, meta interface{}) error {
 
 	d.SetId(resp)
 
-	_, err = waitForImageState(d, meta, "ready")
+	_, err = waitForImageState(d, meta, d.Id(), "ready")
 	if err != nil {
-		return fmt.Errorf("Error waiting for Image (%s) to be in state READY: %s", d.Id(), err)
+		id := d.Id()
+		d.SetId(discardImage(client, id))
+		return fmt.Errorf("Error waiting for Image (%s) to be in state READY: %s", id, err)
 	}
 
 	// update permisions
@@ -159,18 +364,59 @@ func resourceImageCreate(d *schema.ResourceData, meta interface{}) error {
 		return err
 	}
 
+	if !d.Get("enabled").(bool) {
+		if _, err = client.Call("one.image.enable", intId(d.Id()), false); err != nil {
+			return err
+		}
+	}
+
+	if err = changeLock(intId(d.Id()), "", d.Get("lock").(string), client, "one.image"); err != nil {
+		return err
+	}
+
 	return resourceImageRead(d, meta)
 }
 
+// imageTemplate renders the structured attributes that live in the Image's template, and can thus be
+// updated after the Image is created, followed by the description
+func imageTemplate(d *schema.ResourceData) (*template.Template, error) {
+	return renderImageTemplate(d.Get)
+}
+
+// renderImageTemplate renders the template out of the fields get reads, so that both the
+// current and the previous one can be rendered
+func renderImageTemplate(get func(string) interface{}) (*template.Template, error) {
+	description, err := template.Parse(get("description").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl := template.New()
+	for _, attr := range []struct{ key, field string }{
+		{"DEV_PREFIX", "dev_prefix"},
+		{"DRIVER", "driver"},
+	} {
+		if v := get(attr.field).(string); v != "" {
+			tmpl.Add(attr.key, v)
+		}
+	}
+
+	return tmpl.Append(description.Attributes...), nil
+}
+
 func resourceImageClone(d *schema.ResourceData, meta interface{}) error {
 	client := meta.(*Client)
 
-	imageId, err := getImageIdByName(d, meta)
-	if err != nil {
-		return fmt.Errorf("Unable to find Image by name %s", d.Get("clone_from_image"))
+	imageId := d.Get("clone_from_image_id").(int)
+	if imageId < 0 {
+		var err error
+		imageId, err = getImageIdByName(client, d.Get("clone_from_image").(string), d.Get("clone_from_image_owner").(string))
+		if err != nil {
+			return fmt.Errorf("Unable to find Image to clone from: %s", err)
+		}
 	}
 
-	// Clone Image from given ID
+	// Clone Image from given ID, possibly into a datastore of another type
 	resp, err := client.Call(
 		"one.image.clone",
 		imageId,
@@ -183,58 +429,213 @@ func resourceImageClone(d *schema.ResourceData, meta interface{}) error {
 
 	d.SetId(resp)
 
-	_, err = waitForImageState(d, meta, "ready")
+	_, err = waitForImageState(d, meta, d.Id(), "ready")
 	if err != nil {
-		return fmt.Errorf("Error waiting for Image (%s) to be in state READY: %s", d.Id(), err)
+		id := d.Id()
+		d.SetId(discardImage(client, id))
+		return fmt.Errorf("Error waiting for Image (%s) to be in state READY: %s", id, err)
 	}
 
-	// update permisions
-	if _, err = changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.image.chmod"); err != nil {
+	// the source stays in state CLONE until the copy is complete, and can't be used meanwhile
+	_, err = waitForImageState(d, meta, strconv.Itoa(imageId), "ready", "used", "used_pers", "disabled")
+	if err != nil {
+		return fmt.Errorf("Error waiting for the cloned Image (%d) to be released: %s", imageId, err)
+	}
+
+	// the clone only copies the source, so its own attributes have to be set before it's handed out
+	if err = setupClonedImage(d, client); err != nil {
+		id := d.Id()
+		d.SetId(discardImage(client, id))
+		return fmt.Errorf("Error setting up cloned Image (%s): %s", id, err)
+	}
+
+	if err = changeLock(intId(d.Id()), "", d.Get("lock").(string), client, "one.image"); err != nil {
 		return err
 	}
 
-	// set persistency if needed
-	resp, err = client.Call(
-		"one.image.persistent",
-		intId(d.Id()),
-		d.Get("persistent"),
+	return resourceImageRead(d, meta)
+}
+
+func resourceImageExport(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*Client)
+
+	var isPersistent string
+	isPersistent = "NO"
+	if d.Get("persistent").(bool) {
+		isPersistent = "YES"
+	}
+
+	attrs, err := imageTemplate(d)
+	if err != nil {
+		return err
+	}
+
+	imageId, templateId, err := exportMarketApp(
+		client,
+		d.Get("marketplace_app_id").(int),
+		d.Get("name").(string),
+		d.Get("datastore_id").(int),
+		template.New().Add("PERSISTENT", isPersistent).Append(attrs.Attributes...),
 	)
+	if templateId >= 0 {
+		d.Set("exported_template_id", templateId)
+	} else {
+		d.Set("exported_template_id", -1)
+	}
+	if imageId != "" {
+		d.SetId(imageId)
+	}
 	if err != nil {
 		return err
 	}
 
+	_, err = waitForImageState(d, meta, d.Id(), "ready")
+	if err != nil {
+		id := d.Id()
+		// keep the Image in the state along with its template, unless both are gone
+		if discardErr := discardExportedTemplate(d, client); discardErr != nil {
+			return fmt.Errorf("Error waiting for Image (%s) to be in state READY: %s. Could not delete its exported template: %s", id, err, discardErr)
+		}
+		d.SetId(discardImage(client, id))
+		return fmt.Errorf("Error waiting for Image (%s) to be in state READY: %s", id, err)
+	}
+
+	if _, err = changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.image.chmod"); err != nil {
+		return err
+	}
+
+	if !d.Get("enabled").(bool) {
+		if _, err = client.Call("one.image.enable", intId(d.Id()), false); err != nil {
+			return err
+		}
+	}
+
+	if err = changeLock(intId(d.Id()), "", d.Get("lock").(string), client, "one.image"); err != nil {
+		return err
+	}
+
 	return resourceImageRead(d, meta)
 }
 
-func waitForImageState(d *schema.ResourceData, meta interface{}, state string) (interface{}, error) {
-	var img *Image
-	client := meta.(*Client)
+// discardExportedTemplate deletes the VM template that came with a marketplace app, if there is one
+func discardExportedTemplate(d *schema.ResourceData, client *Client) error {
+	templateId := d.Get("exported_template_id").(int)
+	if d.Get("marketplace_app_id").(int) < 0 || templateId < 0 {
+		return nil
+	}
+
+	if _, err := client.Call("one.template.delete", templateId, false); err != nil && !isNotFound(err) {
+		return err
+	}
+
+	log.Printf("[INFO] Deleted exported template %d\n", templateId)
+	d.Set("exported_template_id", -1)
+	return nil
+}
+
+// setupClonedImage applies the permissions, persistence, type, description and enabled flag of the configuration to a fresh clone
+func setupClonedImage(d *schema.ResourceData, client *Client) error {
+	if _, err := changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.image.chmod"); err != nil {
+		return err
+	}
+
+	if err := changeImagePersistence(client, intId(d.Id()), d.Get("persistent").(bool)); err != nil {
+		return err
+	}
+
+	if t := d.Get("type").(string); t != "" {
+		if err := changeImageType(client, intId(d.Id()), t); err != nil {
+			return err
+		}
+	}
+
+	tmpl, err := imageTemplate(d)
+	if err != nil {
+		return err
+	}
+	if len(tmpl.Attributes) > 0 {
+		// merge, as the clone carries the attributes of its source
+		if _, err := client.Call("one.image.update", intId(d.Id()), tmpl.String(), 1); err != nil {
+			return err
+		}
+	}
+
+	if !d.Get("enabled").(bool) {
+		if _, err := client.Call("one.image.enable", intId(d.Id()), false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resourceImageCustomizeDiff replaces an uploaded Image when the local file has changed, even if its path hasn't
+func resourceImageCustomizeDiff(d *schema.ResourceDiff, meta interface{}) error {
+	if d.Id() == "" || d.HasChange("upload_file") {
+		return nil
+	}
 
-	log.Printf("Waiting for Image (%s) to be in state Ready", d.Id())
+	checksum, changed, err := uploadFileChanged(d.Get("upload_file").(string), d.Get("checksum").(string))
+	if err != nil || !changed {
+		return err
+	}
+
+	if err = d.SetNew("checksum", checksum); err != nil {
+		return err
+	}
+	return d.ForceNew("checksum")
+}
+
+// uploadFileChanged compares the checksum of the local file to upload with the one of the last upload.
+// A file that is gone since then is not taken for a change, e.g. when a pipeline has cleaned it up
+func uploadFileChanged(file, uploaded string) (string, bool, error) {
+	if file == "" || uploaded == "" {
+		return "", false, nil
+	}
+
+	checksum, err := fileChecksum(file)
+	if os.IsNotExist(err) {
+		log.Printf("[WARN] Could not find %s to compare it with the uploaded Image", file)
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+
+	return checksum, checksum != uploaded, nil
+}
+
+// imageStuckTimeout is how long an Image may stay in a transient state before waiting for it is given up.
+// Locked Images are being copied or downloaded, which only the timeout of the whole wait bounds
+func imageStuckTimeout(state int) time.Duration {
+	switch state {
+	case imageStateDelete:
+		return 2 * time.Minute
+	default:
+		return 0
+	}
+}
+
+// waitForImageState waits for the Image with the given ID to reach one of the target states,
+// given by their lowercase names, within the create timeout
+func waitForImageState(d *schema.ResourceData, meta interface{}, id string, targets ...string) (interface{}, error) {
+	return waitForImage(meta.(*Client), id, d.Timeout(schema.TimeoutCreate), targets...)
+}
+
+func waitForImage(client *Client, id string, timeout time.Duration, targets ...string) (interface{}, error) {
+	pending := []string{}
+	for _, name := range imageStateNames {
+		if !containsString(targets, strings.ToLower(name)) {
+			pending = append(pending, strings.ToLower(name))
+		}
+	}
+
+	log.Printf("Waiting for Image (%s) to be in state %s", id, strings.ToUpper(strings.Join(targets, " or ")))
 
 	stateConf := &resource.StateChangeConf{
-		Pending: []string{"anythingelse"},
-		Target:  []string{state},
-		Refresh: func() (interface{}, string, error) {
-			log.Println("Refreshing Image state...")
-			if d.Id() != "" {
-				resp, err := client.Call("one.image.info", intId(d.Id()))
-				if err == nil {
-					if err = xml.Unmarshal([]byte(resp), &img); err != nil {
-						return nil, "", fmt.Errorf("Couldn't fetch Image state: %s", err)
-					}
-				} else {
-					return nil, "", fmt.Errorf("Could not find Image by ID %s", d.Id())
-				}
-			}
-			log.Printf("Image is currently in state %v", img.State)
-			if img.State == 1 {
-				return img, "ready", nil
-			} else {
-				return nil, "anythingelse", nil
-			}
-		},
-		Timeout:    10 * time.Minute,
+		Pending:    pending,
+		Target:     targets,
+		Refresh:    imageStateRefreshFunc(client, id),
+		Timeout:    timeout,
 		Delay:      10 * time.Second,
 		MinTimeout: 3 * time.Second,
 	}
@@ -242,50 +643,122 @@ func waitForImageState(d *schema.ResourceData, meta interface{}, state string) (
 	return stateConf.WaitForState()
 }
 
+// imageStateRefreshFunc reads the state of the Image with the given ID, and fails if it is in state ERROR
+// or stuck in a transient state
+func imageStateRefreshFunc(client *Client, id string) resource.StateRefreshFunc {
+	lastState := -1
+	var lastChange time.Time
+
+	return func() (interface{}, string, error) {
+		var img *Image
+
+		log.Println("Refreshing Image state...")
+		resp, err := client.Call("one.image.info", intId(id))
+		if isNotFound(err) {
+			return nil, "", fmt.Errorf("Could not find Image by ID %s", id)
+		} else if err != nil {
+			return nil, "", err
+		}
+		if err = xml.Unmarshal([]byte(resp), &img); err != nil {
+			return nil, "", fmt.Errorf("Couldn't fetch Image state: %s", err)
+		}
+
+		log.Printf("Image is currently in state %s", imageStateName(img.State))
+		if img.State != lastState {
+			lastState = img.State
+			lastChange = time.Now()
+		}
+
+		stuck := imageStuckTimeout(img.State)
+		switch {
+		case img.State == imageStateError:
+			message := ""
+			if img.Template != nil {
+				message = img.Template.Error
+			}
+			return nil, "", fmt.Errorf("Image %s is in state ERROR: %s", id, message)
+		case stuck > 0 && time.Since(lastChange) > stuck:
+			return nil, "", fmt.Errorf("Image %s has been stuck in state %s for more than %s", id, imageStateName(img.State), stuck)
+		default:
+			return img, strings.ToLower(imageStateName(img.State)), nil
+		}
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func imageInfo(client *Client, id int) (*Image, error) {
+	var img *Image
+
+	resp, err := client.Call("one.image.info", id, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = xml.Unmarshal([]byte(resp), &img); err != nil {
+		return nil, err
+	}
+
+	return img, nil
+}
+
+// discardImage deletes an Image that never became usable, instead of leaving it tainted behind.
+// It returns the ID the resource keeps: none if the Image is gone, its own otherwise
+func discardImage(client *Client, id string) string {
+	if _, err := client.Call("one.image.delete", intId(id), false); err != nil {
+		log.Printf("[WARN] Could not delete failed Image %s: %s", id, err)
+		return id
+	}
+
+	log.Printf("[INFO] Deleted failed Image %s\n", id)
+	return ""
+}
+
 func resourceImageRead(d *schema.ResourceData, meta interface{}) error {
 	var img *Image
-	var imgs *Images
 
 	client := meta.(*Client)
-	found := false
 
 	// Try to find the Image by ID, if specified
 	if d.Id() != "" {
 		resp, err := client.Call("one.image.info", intId(d.Id()), false)
 		if err == nil {
-			found = true
 			if err = xml.Unmarshal([]byte(resp), &img); err != nil {
 				return err
 			}
+		} else if !isNotFound(err) {
+			return err
 		} else {
 			log.Printf("Could not find Image by ID %s", d.Id())
 		}
 	}
 
-	// Otherwise, try to find the Image by (user, name) as the de facto compound primary key
-	if d.Id() == "" || !found {
-		resp, err := client.Call("one.imagepool.info", -3, -1, -1)
-		if err != nil {
-			return err
+	// Otherwise, only adopt an Image by (user, name) as the de facto compound primary key if asked to
+	if img == nil {
+		if !d.Get("adopt_by_name").(bool) {
+			d.SetId("")
+			return nil
 		}
 
-		if err = xml.Unmarshal([]byte(resp), &imgs); err != nil {
+		found, err := findImageByName(client, d.Get("name").(string))
+		if err != nil {
 			return err
 		}
 
-		for _, t := range imgs.Image {
-			if t.Name == d.Get("name").(string) {
-				img = t
-				found = true
-				break
-			}
-		}
-
-		if !found || img == nil {
+		if found == nil {
 			d.SetId("")
 			log.Printf("Could not find Image with name %s for user %s", d.Get("name").(string), client.Username)
 			return nil
 		}
+		img = found
 	}
 
 	d.SetId(strconv.Itoa(img.Id))
@@ -294,19 +767,42 @@ func resourceImageRead(d *schema.ResourceData, meta interface{}) error {
 	d.Set("gid", img.Gid)
 	d.Set("uname", img.Uname)
 	d.Set("gname", img.Gname)
+	d.Set("state", img.State)
+	d.Set("state_name", imageStateName(img.State))
+	d.Set("enabled", imageEnabled(img))
 	d.Set("permissions", permissionString(img.Permissions))
+	d.Set("lock", lockLevelName(img.Lock))
+	d.Set("path", img.Path)
+	d.Set("size", img.Size)
+	d.Set("source", img.Source)
+	d.Set("persistent", img.Persistent == "1")
+	d.Set("datastore_id", img.DatastoreID)
+	d.Set("datastore", img.Datastore)
+	d.Set("running_vms", img.RunningVMs)
+	d.Set("reg_time", img.RegTime)
+	if img.Type >= 0 && img.Type < len(imageTypes) {
+		d.Set("type", imageTypes[img.Type])
+	}
+	if img.Template != nil {
+		d.Set("dev_prefix", img.Template.DevPrefix)
+		d.Set("driver", img.Template.Driver)
+	}
 
 	return nil
 }
 
-func getImageIdByName(d *schema.ResourceData, meta interface{}) (int, error) {
-	var img *Image
-	var imgs *Images
+// imageEnabled tells whether new VMs can use the Image, which they can in any state but DISABLED
+func imageEnabled(img *Image) bool {
+	return img.State != imageStateDisabled
+}
 
-	client := meta.(*Client)
-	found := false
+// getImageIdByName looks for the Image to clone from among all the Images visible to the user.
+// If several users have an Image with that name, the owner has to be given.
+func getImageIdByName(client *Client, name, owner string) (int, error) {
+	var imgs *Images
+	var img *Image
 
-	resp, err := client.Call("one.imagepool.info", -3, -1, -1)
+	resp, err := client.Call("one.imagepool.info", -2, -1, -1)
 	if err != nil {
 		return 0, err
 	}
@@ -316,22 +812,53 @@ func getImageIdByName(d *schema.ResourceData, meta interface{}) (int, error) {
 	}
 
 	for _, t := range imgs.Image {
-		if t.Name == d.Get("clone_from_image").(string) {
-			img = t
-			found = true
-			break
+		if t.Name != name || (owner != "" && t.Uname != owner) {
+			continue
 		}
+		if img != nil {
+			return 0, fmt.Errorf("Image name %s is ambiguous (owned by %s and %s), set clone_from_image_owner or use clone_from_image_id", name, img.Uname, t.Uname)
+		}
+		img = t
 	}
 
-	if !found || img == nil {
-		log.Printf("Could not find Image with name %s for user %s", d.Get("clone_from_image").(string), client.Username)
-		err = errors.New("ImageNotFound")
-		return 0, err
+	if img == nil {
+		if owner != "" {
+			return 0, fmt.Errorf("Could not find Image with name %s owned by %s", name, owner)
+		}
+		return 0, fmt.Errorf("Could not find Image with name %s", name)
 	}
 
 	return img.Id, nil
 }
 
+// findImageByName looks for a single Image with the given name that is owned by the user.
+// It returns nil if there is no such Image and fails if the name is ambiguous.
+func findImageByName(client *Client, name string) (*Image, error) {
+	var imgs *Images
+	var img *Image
+
+	resp, err := client.Call("one.imagepool.info", -3, -1, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = xml.Unmarshal([]byte(resp), &imgs); err != nil {
+		return nil, err
+	}
+
+	for _, t := range imgs.Image {
+		if t.Name != name || t.Uname != client.Username {
+			continue
+		}
+		if img != nil {
+			return nil, fmt.Errorf("Found more than one Image with name %s for user %s (IDs %d and %d)", name, client.Username, img.Id, t.Id)
+		}
+		img = t
+	}
+
+	return img, nil
+}
+
 func resourceImageExists(d *schema.ResourceData, meta interface{}) (bool, error) {
 	err := resourceImageRead(d, meta)
 	if err != nil || d.Id() == "" {
@@ -341,19 +868,93 @@ func resourceImageExists(d *schema.ResourceData, meta interface{}) (bool, error)
 	return true, nil
 }
 
-func resourceImageUpdate(d *schema.ResourceData, meta interface{}) error {
+// ensureImageUnused fails if VMs use the Image, as its persistence and type can't change then
+func ensureImageUnused(client *Client, id int) error {
+	img, err := imageInfo(client, id)
+	if err != nil {
+		return err
+	}
+	if img.RunningVMs > 0 {
+		return fmt.Errorf("Image %d is used by %d VMs. Its persistence and type can only change while no VM uses it", id, img.RunningVMs)
+	}
+
+	return nil
+}
+
+func changeImagePersistence(client *Client, id int, persistent bool) error {
+	resp, err := client.Call("one.image.persistent", id, persistent)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] Successfully updated persistence of Image %s\n", resp)
+
+	return nil
+}
+
+func changeImageType(client *Client, id int, imageType string) error {
+	resp, err := client.Call("one.image.chtype", id, imageType)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] Successfully updated type of Image %s\n", resp)
+
+	return nil
+}
+
+func resourceImageUpdate(d *schema.ResourceData, meta interface{}) (err error) {
 	client := meta.(*Client)
 
-	if d.HasChange("description") {
-		_, err := client.Call(
-			"one.image.update",
+	if err = unlockForUpdate(d, client, "one.image"); err != nil {
+		return err
+	}
+	defer relockAfterUpdate(d, client, "one.image", &err)
+
+	if d.HasChange("persistent") || d.HasChange("type") {
+		if err = ensureImageUnused(client, intId(d.Id())); err != nil {
+			return err
+		}
+	}
+
+	if d.HasChange("persistent") {
+		if err = changeImagePersistence(client, intId(d.Id()), d.Get("persistent").(bool)); err != nil {
+			return err
+		}
+	}
+
+	if d.HasChange("type") {
+		if err = changeImageType(client, intId(d.Id()), d.Get("type").(string)); err != nil {
+			return err
+		}
+	}
+
+	if d.HasChange("enabled") {
+		resp, err := client.Call(
+			"one.image.enable",
 			intId(d.Id()),
-			d.Get("description").(string),
-			0, // replace the whole image instead of merging it with the existing one
+			d.Get("enabled").(bool),
 		)
 		if err != nil {
 			return err
 		}
+		log.Printf("[INFO] Successfully updated enabled flag of Image %s\n", resp)
+	}
+
+	if d.HasChange("description") || d.HasChange("dev_prefix") || d.HasChange("driver") {
+		tmpl, err := imageTemplate(d)
+		if err != nil {
+			return err
+		}
+
+		old, err := renderImageTemplate(oldValues(d))
+		if err != nil {
+			// the previous description may predate its validation, in which case no key is removed
+			log.Printf("[WARN] Could not parse the previous template of Image %s: %s\n", d.Id(), err)
+			old = template.New()
+		}
+
+		if err = updateTemplate(client, "image", intId(d.Id()), old, tmpl); err != nil {
+			return err
+		}
 	}
 
 	if d.HasChange("name") {
@@ -379,19 +980,38 @@ func resourceImageUpdate(d *schema.ResourceData, meta interface{}) error {
 	return nil
 }
 
+// deleteImage deletes the Image unless VMs use it, in which case OpenNebula only deletes it if forced to
+func deleteImage(client *Client, id, runningVms int, force bool) error {
+	if runningVms > 0 && !force {
+		return fmt.Errorf("Image %d is used by %d VMs. Set force_delete to delete it anyway", id, runningVms)
+	}
+
+	resp, err := client.Call("one.image.delete", id, force)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Successfully deleted Image %s\n", resp)
+	return nil
+}
+
 func resourceImageDelete(d *schema.ResourceData, meta interface{}) error {
 	err := resourceImageRead(d, meta)
 	if err != nil || d.Id() == "" {
 		return err
 	}
 
-	client := meta.(*Client)
+	if err = checkUnlocked("Image", d.Id(), d.Get("lock").(string)); err != nil {
+		return err
+	}
 
-	resp, err := client.Call("one.image.delete", intId(d.Id()), false)
-	if err != nil {
+	client := meta.(*Client)
+	if err = deleteImage(client, intId(d.Id()), d.Get("running_vms").(int), d.Get("force_delete").(bool)); err != nil {
 		return err
 	}
 
-	log.Printf("[INFO] Successfully deleted Image %s\n", resp)
+	if err = discardExportedTemplate(d, client); err != nil {
+		return err
+	}
 	return nil
 }
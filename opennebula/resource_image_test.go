@@ -0,0 +1,266 @@
+package opennebula
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDeleteImage(t *testing.T) {
+	client, standIn := newTestClient(t, map[string]func([]string) (interface{}, error){
+		"one.image.delete": func(params []string) (interface{}, error) {
+			if params[1] != "1" {
+				return nil, fmt.Errorf("[ImageDelete] Cannot delete image, it is in use (state USED)")
+			}
+			return 5, nil
+		},
+	})
+	defer standIn.Close()
+
+	// the provider refuses to delete a used Image on its own...
+	if err := deleteImage(client, 5, 2, false); err == nil {
+		t.Errorf("Expected deleting an Image used by VMs to fail")
+	}
+	if len(standIn.Calls) != 0 {
+		t.Errorf("Expected no calls for an Image used by VMs, got %v", standIn.Calls)
+	}
+
+	// ...as does OpenNebula, if the Image got used meanwhile
+	if err := deleteImage(client, 5, 0, false); err == nil {
+		t.Errorf("Expected OpenNebula to refuse deleting a used Image")
+	}
+
+	standIn.Calls = nil
+	if err := deleteImage(client, 5, 2, true); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []xmlRpcCall{
+		{Method: "one.image.delete", Params: []string{"5", "1"}},
+	}
+	standIn.checkCalls(t, expected)
+}
+
+func TestImageEnabled(t *testing.T) {
+	client, standIn := newTestClient(t, map[string]func([]string) (interface{}, error){
+		"one.image.info": func(params []string) (interface{}, error) {
+			return fmt.Sprintf("<IMAGE><ID>%s</ID><STATE>%s</STATE></IMAGE>", params[0], params[0]), nil
+		},
+	})
+	defer standIn.Close()
+
+	// the Images of the stand-in are in the state of their ID
+	for state, expected := range map[int]bool{
+		imageStateReady:    true,
+		imageStateUsed:     true,
+		imageStateDisabled: false,
+		imageStateUsedPers: true,
+	} {
+		img, err := imageInfo(client, state)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if enabled := imageEnabled(img); enabled != expected {
+			t.Errorf("Expected an Image in state %s to have enabled = %t, got %t", imageStateName(state), expected, enabled)
+		}
+	}
+}
+
+func TestImageStateRefreshFunc(t *testing.T) {
+	client, standIn := newTestClient(t, map[string]func([]string) (interface{}, error){
+		"one.image.info": func(params []string) (interface{}, error) {
+			switch params[0] {
+			case "1":
+				return "<IMAGE><ID>1</ID><STATE>1</STATE></IMAGE>", nil
+			case "4":
+				return "<IMAGE><ID>4</ID><STATE>4</STATE></IMAGE>", nil
+			case "5":
+				return "<IMAGE><ID>5</ID><STATE>5</STATE><TEMPLATE><ERROR><![CDATA[Error copying image in the datastore]]></ERROR></TEMPLATE></IMAGE>", nil
+			case "6":
+				return nil, &Error{Code: ErrNoExists, Message: "[one.image.info] Error getting image [6]."}
+			default:
+				return nil, &Error{Code: ErrAuthorization, Message: "[one.image.info] User [3] : Not authorized to perform USE IMAGE [7]."}
+			}
+		},
+	})
+	defer standIn.Close()
+
+	if _, state, err := imageStateRefreshFunc(client, "1")(); err != nil || state != "ready" {
+		t.Errorf("Expected Image 1 to be ready, got %q (err: %v)", state, err)
+	}
+
+	_, _, err := imageStateRefreshFunc(client, "5")()
+	if err == nil || !strings.Contains(err.Error(), "Error copying image in the datastore") {
+		t.Errorf("Expected the error of Image 5 to come with its ERROR message, got %v", err)
+	}
+
+	// a download or copy may keep the Image locked for as long as the wait lasts
+	refresh := imageStateRefreshFunc(client, "4")
+	for i := 0; i < 2; i++ {
+		if _, state, err := refresh(); err != nil || state != "locked" {
+			t.Errorf("Expected Image 4 to be locked, got %q (err: %v)", state, err)
+		}
+	}
+	if stuck := imageStuckTimeout(imageStateLocked); stuck != 0 {
+		t.Errorf("Expected a locked Image never to be stuck, got %s", stuck)
+	}
+	if stuck := imageStuckTimeout(imageStateDelete); stuck <= 0 {
+		t.Errorf("Expected an Image being deleted to be stuck at some point, got %s", stuck)
+	}
+
+	_, _, err = imageStateRefreshFunc(client, "6")()
+	if err == nil || !strings.Contains(err.Error(), "Could not find Image by ID 6") {
+		t.Errorf("Expected Image 6 to be gone, got %v", err)
+	}
+
+	// errors other than a missing Image are returned as they are
+	_, _, err = imageStateRefreshFunc(client, "7")()
+	if err == nil || !strings.Contains(err.Error(), "Not authorized") {
+		t.Errorf("Expected the error of Image 7 to be returned, got %v", err)
+	}
+}
+
+func TestDiscardImage(t *testing.T) {
+	client, standIn := newTestClient(t, map[string]func([]string) (interface{}, error){
+		"one.image.delete": func(params []string) (interface{}, error) {
+			if params[0] == "6" {
+				return nil, fmt.Errorf("[ImageDelete] Cannot delete image")
+			}
+			return 5, nil
+		},
+	})
+	defer standIn.Close()
+
+	if id := discardImage(client, "5"); id != "" {
+		t.Errorf("Expected the failed Image to be gone, got ID %q", id)
+	}
+	if id := discardImage(client, "6"); id != "6" {
+		t.Errorf("Expected the Image that couldn't be deleted to be kept, got ID %q", id)
+	}
+
+	expected := []xmlRpcCall{
+		{Method: "one.image.delete", Params: []string{"5", "0"}},
+		{Method: "one.image.delete", Params: []string{"6", "0"}},
+	}
+	standIn.checkCalls(t, expected)
+}
+
+func TestChangeImagePersistenceAndType(t *testing.T) {
+	client, standIn := newTestClient(t, map[string]func([]string) (interface{}, error){
+		"one.image.info": func(params []string) (interface{}, error) {
+			if params[0] == "6" {
+				return "<IMAGE><ID>6</ID><STATE>2</STATE><RUNNING_VMS>2</RUNNING_VMS></IMAGE>", nil
+			}
+			return "<IMAGE><ID>5</ID><STATE>1</STATE><RUNNING_VMS>0</RUNNING_VMS></IMAGE>", nil
+		},
+		"one.image.persistent": func(params []string) (interface{}, error) { return 5, nil },
+		"one.image.chtype":     func(params []string) (interface{}, error) { return 5, nil },
+	})
+	defer standIn.Close()
+
+	err := ensureImageUnused(client, 6)
+	if err == nil || !strings.Contains(err.Error(), "used by 2 VMs") {
+		t.Errorf("Expected changing an Image used by VMs to fail, got %v", err)
+	}
+
+	if err = ensureImageUnused(client, 5); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err = changeImagePersistence(client, 5, true); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err = changeImageType(client, 5, "DATABLOCK"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []xmlRpcCall{
+		{Method: "one.image.info", Params: []string{"6", "0"}},
+		{Method: "one.image.info", Params: []string{"5", "0"}},
+		{Method: "one.image.persistent", Params: []string{"5", "1"}},
+		{Method: "one.image.chtype", Params: []string{"5", "DATABLOCK"}},
+	}
+	standIn.checkCalls(t, expected)
+}
+
+func TestGetImageIdByName(t *testing.T) {
+	client, standIn := newTestClient(t, map[string]func([]string) (interface{}, error){
+		"one.imagepool.info": func(params []string) (interface{}, error) {
+			return `<IMAGE_POOL>
+<IMAGE><ID>1</ID><NAME>ubuntu</NAME><UNAME>oneadmin</UNAME></IMAGE>
+<IMAGE><ID>2</ID><NAME>ubuntu</NAME><UNAME>serveradmin</UNAME></IMAGE>
+<IMAGE><ID>3</ID><NAME>debian</NAME><UNAME>serveradmin</UNAME></IMAGE>
+</IMAGE_POOL>`, nil
+		},
+	})
+	defer standIn.Close()
+
+	for _, tc := range []struct {
+		name, owner string
+		id          int
+		err         string
+	}{
+		{name: "debian", id: 3},
+		{name: "ubuntu", owner: "serveradmin", id: 2},
+		{name: "ubuntu", owner: "oneadmin", id: 1},
+		{name: "ubuntu", err: "ambiguous"},
+		{name: "debian", owner: "oneadmin", err: "owned by oneadmin"},
+		{name: "centos", err: "Could not find Image with name centos"},
+	} {
+		id, err := getImageIdByName(client, tc.name, tc.owner)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("Expected looking up %s owned by %q to fail with %q, got %d (err: %v)", tc.name, tc.owner, tc.err, id, err)
+			}
+		} else if err != nil || id != tc.id {
+			t.Errorf("Expected %s owned by %q to be Image %d, got %d (err: %v)", tc.name, tc.owner, tc.id, id, err)
+		}
+	}
+
+	// all Images visible to the user are candidates
+	expected := xmlRpcCall{Method: "one.imagepool.info", Params: []string{"-2", "-1", "-1"}}
+	if !reflect.DeepEqual(standIn.Calls[0], expected) {
+		t.Errorf("Expected call %v, got %v", expected, standIn.Calls[0])
+	}
+}
+
+func TestUploadFileChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opennebula-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "disk.qcow2")
+	if err = ioutil.WriteFile(path, []byte("first build"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	first := sha1.Sum([]byte("first build"))
+	uploaded := hex.EncodeToString(first[:])
+
+	if _, changed, err := uploadFileChanged(path, uploaded); err != nil || changed {
+		t.Errorf("Expected the uploaded file to be unchanged (err: %v)", err)
+	}
+
+	// a pipeline rebuilds the file at the same path
+	if err = ioutil.WriteFile(path, []byte("second build"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	second := sha1.Sum([]byte("second build"))
+	checksum, changed, err := uploadFileChanged(path, uploaded)
+	if err != nil || !changed || checksum != hex.EncodeToString(second[:]) {
+		t.Errorf("Expected the rebuilt file to change the checksum to %s, got %s (changed: %t, err: %v)", hex.EncodeToString(second[:]), checksum, changed, err)
+	}
+
+	if _, changed, err = uploadFileChanged(filepath.Join(dir, "missing.qcow2"), uploaded); err != nil || changed {
+		t.Errorf("Expected a file that is gone not to be taken for a change (changed: %t, err: %v)", changed, err)
+	}
+	if _, changed, err = uploadFileChanged("", ""); err != nil || changed {
+		t.Errorf("Expected an Image without upload never to change (changed: %t, err: %v)", changed, err)
+	}
+}
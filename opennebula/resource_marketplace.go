@@ -0,0 +1,485 @@
+package opennebula
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
+	"github.com/runtastic/terraform-provider-opennebula/opennebula/template"
+	"log"
+	"strconv"
+	"strings"
+)
+
+type Market struct {
+	Name        string          `xml:"NAME"`
+	Id          int             `xml:"ID"`
+	Uid         int             `xml:"UID"`
+	Gid         int             `xml:"GID"`
+	Uname       string          `xml:"UNAME"`
+	Gname       string          `xml:"GNAME"`
+	Permissions *Permissions    `xml:"PERMISSIONS"`
+	MarketMad   string          `xml:"MARKET_MAD"`
+	ZoneId      string          `xml:"ZONE_ID"`
+	TotalMB     int             `xml:"TOTAL_MB"`
+	FreeMB      int             `xml:"FREE_MB"`
+	UsedMB      int             `xml:"USED_MB"`
+	AppIds      []int           `xml:"MARKETPLACEAPPS>ID"`
+	Template    *MarketTemplate `xml:"TEMPLATE"`
+}
+
+type MarketTemplate struct {
+	BaseUrl          string `xml:"BASE_URL"`
+	PublicDir        string `xml:"PUBLIC_DIR"`
+	BridgeList       string `xml:"BRIDGE_LIST"`
+	AccessKeyId      string `xml:"ACCESS_KEY_ID"`
+	SecretAccessKey  string `xml:"SECRET_ACCESS_KEY"`
+	Bucket           string `xml:"BUCKET"`
+	Region           string `xml:"REGION"`
+	Endpoint         string `xml:"ENDPOINT"`
+	SignatureVersion string `xml:"SIGNATURE_VERSION"`
+	ForcePathStyle   string `xml:"FORCE_PATH_STYLE"`
+	TotalMB          int    `xml:"TOTAL_MB"`
+	ReadLength       int    `xml:"READ_LENGTH"`
+}
+
+// Template attributes configuring each marketplace driver, keyed by the driver, which is
+// also the name of its configuration block
+var marketDrivers = map[string][]marketAttribute{
+	"http": {
+		{"base_url", "BASE_URL"},
+		{"public_dir", "PUBLIC_DIR"},
+		{"bridge_list", "BRIDGE_LIST"},
+	},
+	"s3": {
+		{"access_key_id", "ACCESS_KEY_ID"},
+		{"secret_access_key", "SECRET_ACCESS_KEY"},
+		{"bucket", "BUCKET"},
+		{"region", "REGION"},
+		{"endpoint", "ENDPOINT"},
+		{"signature_version", "SIGNATURE_VERSION"},
+		{"force_path_style", "FORCE_PATH_STYLE"},
+		{"total_mb", "TOTAL_MB"},
+		{"read_length", "READ_LENGTH"},
+	},
+	"one": {
+		{"endpoint", "ENDPOINT"},
+	},
+}
+
+type marketAttribute struct {
+	field string
+	key   string
+}
+
+func resourceMarket() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceMarketCreate,
+		Read:   resourceMarketRead,
+		Exists: resourceMarketExists,
+		Update: resourceMarketUpdate,
+		Delete: resourceMarketDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Name of the marketplace",
+			},
+			"permissions": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Permissions for the marketplace (in Unix format, owner-group-other, use-manage-admin)",
+				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+					value := v.(string)
+
+					if len(value) != 3 {
+						errors = append(errors, fmt.Errorf("%q has specify 3 permission sets: owner-group-other", k))
+					}
+
+					all := true
+					for _, c := range strings.Split(value, "") {
+						if c < "0" || c > "7" {
+							all = false
+						}
+					}
+					if !all {
+						errors = append(errors, fmt.Errorf("Each character in %q should specify a Unix-like permission set with a number from 0 to 7", k))
+					}
+
+					return
+				},
+			},
+
+			"uid": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Computed:    true,
+				Description: "ID of the user that will own the marketplace",
+			},
+			"gid": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Computed:    true,
+				Description: "ID of the group that will own the marketplace",
+			},
+			"uname": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the user that will own the marketplace",
+			},
+			"gname": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the group that will own the marketplace",
+			},
+			"market_mad": {
+				Type:         schema.TypeString,
+				Required:     true,
+				ForceNew:     true,
+				Description:  "Driver of the marketplace: http, s3 or one. Configured through the block of the same name",
+				ValidateFunc: validation.StringInSlice([]string{"http", "s3", "one"}, false),
+			},
+			"http": {
+				Type:          schema.TypeList,
+				Optional:      true,
+				MaxItems:      1,
+				ConflictsWith: []string{"s3", "one"},
+				Description:   "Configuration of a marketplace served over HTTP from the frontend",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"base_url": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "URL the marketplace is served from",
+						},
+						"public_dir": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "Directory the HTTP server serves the apps from",
+						},
+						"bridge_list": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Space separated list of the hosts that copy the apps into the public directory",
+						},
+					},
+				},
+			},
+			"s3": {
+				Type:          schema.TypeList,
+				Optional:      true,
+				MaxItems:      1,
+				ConflictsWith: []string{"http", "one"},
+				Description:   "Configuration of a marketplace backed by an S3 bucket",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"access_key_id": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"secret_access_key": {
+							Type:      schema.TypeString,
+							Required:  true,
+							Sensitive: true,
+						},
+						"bucket": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"region": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"endpoint": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "URL of the S3 service, if it isn't AWS",
+						},
+						"signature_version": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validation.StringInSlice([]string{"s3", "v4"}, false),
+						},
+						"force_path_style": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validation.StringInSlice([]string{"YES", "NO"}, false),
+						},
+						"total_mb": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Description: "Capacity of the bucket in MB",
+						},
+						"read_length": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Description: "Size in MB of the chunks the apps are read in",
+						},
+					},
+				},
+			},
+			"one": {
+				Type:          schema.TypeList,
+				Optional:      true,
+				MaxItems:      1,
+				ConflictsWith: []string{"http", "s3"},
+				Description:   "Configuration of the public OpenNebula marketplace",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"endpoint": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "URL of the marketplace, if it isn't the official one",
+						},
+					},
+				},
+			},
+			"total_mb": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Capacity of the marketplace in MB",
+			},
+			"free_mb": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Free space of the marketplace in MB",
+			},
+			"used_mb": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Used space of the marketplace in MB",
+			},
+			"app_ids": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "IDs of the apps of the marketplace",
+				Elem:        &schema.Schema{Type: schema.TypeInt},
+			},
+			"app_count": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Number of apps of the marketplace",
+			},
+		},
+	}
+}
+
+// marketTemplate renders the driver configuration of the marketplace
+func marketTemplate(d *schema.ResourceData) (*template.Template, error) {
+	driver := d.Get("market_mad").(string)
+	tmpl := template.New().Add("MARKET_MAD", driver)
+
+	blocks := d.Get(driver).([]interface{})
+	if len(blocks) == 0 || blocks[0] == nil {
+		if driver != "one" {
+			return nil, fmt.Errorf("Marketplaces with market_mad %s need a %s block", driver, driver)
+		}
+		return tmpl, nil
+	}
+
+	config := blocks[0].(map[string]interface{})
+	for _, attr := range marketDrivers[driver] {
+		switch v := config[attr.field].(type) {
+		case string:
+			if v != "" {
+				tmpl.Add(attr.key, v)
+			}
+		case int:
+			if v > 0 {
+				tmpl.Add(attr.key, v)
+			}
+		}
+	}
+
+	return tmpl, nil
+}
+
+func resourceMarketCreate(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*Client)
+
+	tmpl, err := marketTemplate(d)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Call(
+		"one.market.allocate",
+		template.New().Add("NAME", d.Get("name").(string)).Append(tmpl.Attributes...).String(),
+	)
+	if err != nil {
+		return err
+	}
+
+	d.SetId(resp)
+
+	if _, err = changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.market.chmod"); err != nil {
+		return err
+	}
+
+	if err = changeOwnership(d, client, "one.market.chown"); err != nil {
+		return err
+	}
+
+	return resourceMarketRead(d, meta)
+}
+
+func resourceMarketRead(d *schema.ResourceData, meta interface{}) error {
+	var market *Market
+
+	client := meta.(*Client)
+
+	resp, err := client.Call("one.market.info", intId(d.Id()))
+	if err != nil {
+		if isNotFound(err) {
+			log.Printf("Could not find marketplace by ID %s", d.Id())
+			d.SetId("")
+			return nil
+		}
+		return err
+	}
+
+	if err = xml.Unmarshal([]byte(resp), &market); err != nil {
+		return err
+	}
+
+	d.SetId(strconv.Itoa(market.Id))
+	d.Set("name", market.Name)
+	d.Set("uid", market.Uid)
+	d.Set("gid", market.Gid)
+	d.Set("uname", market.Uname)
+	d.Set("gname", market.Gname)
+	d.Set("permissions", permissionString(market.Permissions))
+	d.Set("market_mad", market.MarketMad)
+	d.Set("total_mb", market.TotalMB)
+	d.Set("free_mb", market.FreeMB)
+	d.Set("used_mb", market.UsedMB)
+	d.Set("app_count", len(market.AppIds))
+	if err = d.Set("app_ids", market.AppIds); err != nil {
+		return err
+	}
+
+	if market.Template != nil {
+		if err = setMarketDriverAttributes(d, market); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func setMarketDriverAttributes(d *schema.ResourceData, market *Market) error {
+	t := market.Template
+	var config map[string]interface{}
+
+	switch market.MarketMad {
+	case "http":
+		config = map[string]interface{}{
+			"base_url":    t.BaseUrl,
+			"public_dir":  t.PublicDir,
+			"bridge_list": t.BridgeList,
+		}
+	case "s3":
+		config = map[string]interface{}{
+			"access_key_id":     t.AccessKeyId,
+			"secret_access_key": t.SecretAccessKey,
+			"bucket":            t.Bucket,
+			"region":            t.Region,
+			"endpoint":          t.Endpoint,
+			"signature_version": t.SignatureVersion,
+			"force_path_style":  t.ForcePathStyle,
+			"total_mb":          t.TotalMB,
+			"read_length":       t.ReadLength,
+		}
+	case "one":
+		// the public marketplace needs no configuration, so only keep the block if it's used
+		if t.Endpoint == "" && len(d.Get("one").([]interface{})) == 0 {
+			return nil
+		}
+		config = map[string]interface{}{
+			"endpoint": t.Endpoint,
+		}
+	default:
+		return nil
+	}
+
+	return d.Set(market.MarketMad, []interface{}{config})
+}
+
+func resourceMarketExists(d *schema.ResourceData, meta interface{}) (bool, error) {
+	err := resourceMarketRead(d, meta)
+	if err != nil || d.Id() == "" {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func resourceMarketUpdate(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*Client)
+
+	if d.HasChange("name") {
+		resp, err := client.Call(
+			"one.market.rename",
+			intId(d.Id()),
+			d.Get("name").(string),
+		)
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated name for marketplace %s\n", resp)
+	}
+
+	if d.HasChange("http") || d.HasChange("s3") || d.HasChange("one") {
+		tmpl, err := marketTemplate(d)
+		if err != nil {
+			return err
+		}
+
+		_, err = client.Call(
+			"one.market.update",
+			intId(d.Id()),
+			tmpl.String(),
+			0, // replace the whole marketplace instead of merging it with the existing one
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if d.HasChange("permissions") {
+		resp, err := changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.market.chmod")
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated marketplace %s\n", resp)
+	}
+
+	if d.HasChange("uid") || d.HasChange("gid") {
+		if err := changeOwnership(d, client, "one.market.chown"); err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated owner of marketplace %s\n", d.Id())
+	}
+
+	return nil
+}
+
+func resourceMarketDelete(d *schema.ResourceData, meta interface{}) error {
+	err := resourceMarketRead(d, meta)
+	if err != nil || d.Id() == "" {
+		return err
+	}
+
+	client := meta.(*Client)
+	resp, err := client.Call("one.market.delete", intId(d.Id()))
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Successfully deleted marketplace %s\n", resp)
+	return nil
+}
@@ -0,0 +1,424 @@
+package opennebula
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/runtastic/terraform-provider-opennebula/opennebula/template"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type MarketApp struct {
+	Name          string             `xml:"NAME"`
+	Id            int                `xml:"ID"`
+	Uid           int                `xml:"UID"`
+	Gid           int                `xml:"GID"`
+	Uname         string             `xml:"UNAME"`
+	Gname         string             `xml:"GNAME"`
+	Permissions   *Permissions       `xml:"PERMISSIONS"`
+	RegTime       int                `xml:"REGTIME"`
+	OriginId      int                `xml:"ORIGIN_ID"`
+	Source        string             `xml:"SOURCE"`
+	MD5           string             `xml:"MD5"`
+	Size          int                `xml:"SIZE"`
+	Description   string             `xml:"DESCRIPTION"`
+	Version       string             `xml:"VERSION"`
+	Format        string             `xml:"FORMAT"`
+	AppTemplate64 string             `xml:"APPTEMPLATE64"`
+	MarketId      int                `xml:"MARKETPLACE_ID"`
+	Market        string             `xml:"MARKETPLACE"`
+	State         int                `xml:"STATE"`
+	Type          int                `xml:"TYPE"`
+	Template      *MarketAppTemplate `xml:"TEMPLATE"`
+}
+
+type MarketAppTemplate struct {
+	VmTemplate64 string `xml:"VMTEMPLATE64"`
+}
+
+type MarketApps struct {
+	MarketApp []*MarketApp `xml:"MARKETPLACEAPP"`
+}
+
+// Marketplace app states and types, indexed by the code OpenNebula uses for them
+var marketAppStateNames = []string{"INIT", "READY", "LOCKED", "ERROR", "DISABLED"}
+var marketAppTypes = []string{"UNKNOWN", "IMAGE", "VMTEMPLATE", "SERVICE_TEMPLATE"}
+
+const (
+	marketAppStateReady = 1
+	marketAppStateError = 3
+	marketAppTypeImage  = 1
+)
+
+func resourceMarketApp() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceMarketAppCreate,
+		Read:   resourceMarketAppRead,
+		Exists: resourceMarketAppExists,
+		Update: resourceMarketAppUpdate,
+		Delete: resourceMarketAppDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(10 * time.Minute),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Name of the marketplace app",
+			},
+			"market_id": {
+				Type:        schema.TypeInt,
+				Required:    true,
+				ForceNew:    true,
+				Description: "ID of the marketplace the app is published in",
+			},
+			"image_id": {
+				Type:        schema.TypeInt,
+				Required:    true,
+				ForceNew:    true,
+				Description: "ID of the Image the app is created from",
+			},
+			"description": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Description of the app, as shown in the marketplace",
+			},
+			"version": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Version of the app",
+			},
+			"permissions": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Permissions for the marketplace app (in Unix format, owner-group-other, use-manage-admin)",
+				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+					value := v.(string)
+
+					if len(value) != 3 {
+						errors = append(errors, fmt.Errorf("%q has specify 3 permission sets: owner-group-other", k))
+					}
+
+					all := true
+					for _, c := range strings.Split(value, "") {
+						if c < "0" || c > "7" {
+							all = false
+						}
+					}
+					if !all {
+						errors = append(errors, fmt.Errorf("Each character in %q should specify a Unix-like permission set with a number from 0 to 7", k))
+					}
+
+					return
+				},
+			},
+
+			"uid": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the user that will own the marketplace app",
+			},
+			"gid": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the group that will own the marketplace app",
+			},
+			"uname": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the user that will own the marketplace app",
+			},
+			"gname": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the group that will own the marketplace app",
+			},
+			"market": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the marketplace the app is published in",
+			},
+			"type": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Type of the app: IMAGE, VMTEMPLATE or SERVICE_TEMPLATE",
+			},
+			"state": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Current state of the app, e.g. READY",
+			},
+			"size": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Size of the app in MB",
+			},
+			"md5": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "MD5 checksum of the app contents",
+			},
+			"source": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Location of the app contents within the marketplace",
+			},
+			"reg_time": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Registration time",
+			},
+		},
+	}
+}
+
+func resourceMarketAppCreate(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*Client)
+
+	tmpl := template.New().Add("NAME", d.Get("name").(string)).Add("ORIGIN_ID", d.Get("image_id").(int)).Add("TYPE", "IMAGE")
+	if v := d.Get("description").(string); v != "" {
+		tmpl.Add("DESCRIPTION", v)
+	}
+	if v := d.Get("version").(string); v != "" {
+		tmpl.Add("VERSION", v)
+	}
+
+	resp, err := client.Call(
+		"one.marketapp.allocate",
+		tmpl.String(),
+		d.Get("market_id").(int),
+	)
+	if err != nil {
+		return err
+	}
+
+	d.SetId(resp)
+
+	if _, err = waitForMarketAppReady(client, d.Id(), d.Timeout(schema.TimeoutCreate)); err != nil {
+		return fmt.Errorf("Error waiting for marketplace app (%s) to be in state READY: %s", d.Id(), err)
+	}
+
+	if _, err = changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.marketapp.chmod"); err != nil {
+		return err
+	}
+
+	return resourceMarketAppRead(d, meta)
+}
+
+func waitForMarketAppReady(client *Client, id string, timeout time.Duration) (interface{}, error) {
+	log.Printf("Waiting for marketplace app (%s) to be in state READY", id)
+
+	stateConf := &resource.StateChangeConf{
+		Pending: []string{"anythingelse"},
+		Target:  []string{"ready"},
+		Refresh: func() (interface{}, string, error) {
+			log.Println("Refreshing marketplace app state...")
+			app, err := marketAppInfo(client, intId(id))
+			if err != nil {
+				return nil, "", fmt.Errorf("Couldn't fetch marketplace app state: %s", err)
+			}
+
+			log.Printf("Marketplace app is currently in state %s", stateName(marketAppStateNames, app.State))
+			switch app.State {
+			case marketAppStateReady:
+				return app, "ready", nil
+			case marketAppStateError:
+				return nil, "", fmt.Errorf("Marketplace app %s is in state ERROR", id)
+			default:
+				return nil, "anythingelse", nil
+			}
+		},
+		Timeout:    timeout,
+		Delay:      10 * time.Second,
+		MinTimeout: 3 * time.Second,
+	}
+
+	return stateConf.WaitForState()
+}
+
+func marketAppInfo(client *Client, id int) (*MarketApp, error) {
+	var app *MarketApp
+
+	resp, err := client.Call("one.marketapp.info", id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = xml.Unmarshal([]byte(resp), &app); err != nil {
+		return nil, err
+	}
+
+	return app, nil
+}
+
+func resourceMarketAppRead(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*Client)
+
+	app, err := marketAppInfo(client, intId(d.Id()))
+	if err != nil {
+		if isNotFound(err) {
+			log.Printf("Could not find marketplace app by ID %s", d.Id())
+			d.SetId("")
+			return nil
+		}
+		return err
+	}
+
+	setMarketAppAttributes(d, app)
+	d.Set("image_id", app.OriginId)
+	d.Set("description", app.Description)
+	d.Set("version", app.Version)
+	d.Set("permissions", permissionString(app.Permissions))
+
+	return nil
+}
+
+// setMarketAppAttributes sets the attributes shared by the marketplace app resource and data source
+func setMarketAppAttributes(d *schema.ResourceData, app *MarketApp) {
+	d.SetId(strconv.Itoa(app.Id))
+	d.Set("name", app.Name)
+	d.Set("market_id", app.MarketId)
+	d.Set("market", app.Market)
+	d.Set("uid", app.Uid)
+	d.Set("gid", app.Gid)
+	d.Set("uname", app.Uname)
+	d.Set("gname", app.Gname)
+	d.Set("type", stateName(marketAppTypes, app.Type))
+	d.Set("state", stateName(marketAppStateNames, app.State))
+	d.Set("size", app.Size)
+	d.Set("md5", app.MD5)
+	d.Set("source", app.Source)
+	d.Set("reg_time", app.RegTime)
+}
+
+func resourceMarketAppExists(d *schema.ResourceData, meta interface{}) (bool, error) {
+	err := resourceMarketAppRead(d, meta)
+	if err != nil || d.Id() == "" {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func resourceMarketAppUpdate(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*Client)
+
+	if d.HasChange("name") {
+		resp, err := client.Call(
+			"one.marketapp.rename",
+			intId(d.Id()),
+			d.Get("name").(string),
+		)
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated name for marketplace app %s\n", resp)
+	}
+
+	if d.HasChange("description") || d.HasChange("version") {
+		_, err := client.Call(
+			"one.marketapp.update",
+			intId(d.Id()),
+			template.New().Add("DESCRIPTION", d.Get("description").(string)).Add("VERSION", d.Get("version").(string)).String(),
+			1, // merge the attributes into the existing app
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if d.HasChange("permissions") {
+		resp, err := changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.marketapp.chmod")
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated marketplace app %s\n", resp)
+	}
+
+	return nil
+}
+
+func resourceMarketAppDelete(d *schema.ResourceData, meta interface{}) error {
+	err := resourceMarketAppRead(d, meta)
+	if err != nil || d.Id() == "" {
+		return err
+	}
+
+	client := meta.(*Client)
+	resp, err := client.Call("one.marketapp.delete", intId(d.Id()))
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Successfully deleted marketplace app %s\n", resp)
+	return nil
+}
+
+// exportMarketApp creates an Image (and a VM template, if the app has one) from a marketplace app.
+// OpenNebula leaves the export to its clients, so this mirrors what the CLI and Sunstone do.
+// It returns the IDs of the Image and the VM template, which is -1 if none was created.
+func exportMarketApp(client *Client, appId int, name string, datastoreId int, extraTemplate *template.Template) (string, int, error) {
+	app, err := marketAppInfo(client, appId)
+	if err != nil {
+		return "", -1, err
+	}
+
+	if app.Type != marketAppTypeImage {
+		return "", -1, fmt.Errorf("Marketplace app %d is of type %s, only IMAGE apps can be exported", appId, stateName(marketAppTypes, app.Type))
+	}
+
+	imageTemplate, err := decodeMarketAppTemplate(app.AppTemplate64)
+	if err != nil {
+		return "", -1, fmt.Errorf("Could not decode the Image template of marketplace app %d: %s", appId, err)
+	}
+	imageTemplate.Del("NAME").Add("NAME", name).Add("FROM_APP", appId).Append(extraTemplate.Attributes...)
+
+	imageId, err := client.Call(
+		"one.image.allocate",
+		imageTemplate.String(),
+		datastoreId,
+	)
+	if err != nil {
+		return "", -1, err
+	}
+
+	if app.Template == nil || app.Template.VmTemplate64 == "" {
+		return imageId, -1, nil
+	}
+
+	// an Image without the VM template of its app is of no use, so it goes if the template can't be created
+	vmTemplate, err := decodeMarketAppTemplate(app.Template.VmTemplate64)
+	if err != nil {
+		return discardImage(client, imageId), -1, fmt.Errorf("Could not decode the VM template of marketplace app %d: %s", appId, err)
+	}
+	vmTemplate.Del("NAME").Add("NAME", name).AddVector("DISK").Add("IMAGE_ID", imageId)
+
+	templateId, err := client.Call(
+		"one.template.allocate",
+		vmTemplate.String(),
+	)
+	if err != nil {
+		return discardImage(client, imageId), -1, err
+	}
+
+	return imageId, intId(templateId), nil
+}
+
+// decodeMarketAppTemplate decodes a base64 encoded template of a marketplace app
+func decodeMarketAppTemplate(encoded string) (*template.Template, error) {
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	return template.Parse(string(decoded))
+}
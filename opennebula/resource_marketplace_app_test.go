@@ -0,0 +1,109 @@
+package opennebula
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/runtastic/terraform-provider-opennebula/opennebula/template"
+	"strings"
+	"testing"
+)
+
+func TestExportMarketApp(t *testing.T) {
+	appTemplate := base64.StdEncoding.EncodeToString([]byte("DEV_PREFIX = \"vd\"\nPATH = \"http://marketplace/appliance/42/download/0\"\n"))
+	vmTemplate := base64.StdEncoding.EncodeToString([]byte("CPU = \"1\"\nMEMORY = \"768\"\n"))
+
+	client, standIn := newTestClient(t, map[string]func([]string) (interface{}, error){
+		"one.marketapp.info": func(params []string) (interface{}, error) {
+			return `<MARKETPLACEAPP><ID>42</ID><NAME>Alpine</NAME><TYPE>1</TYPE><STATE>1</STATE>` +
+				`<APPTEMPLATE64>` + appTemplate + `</APPTEMPLATE64>` +
+				`<TEMPLATE><VMTEMPLATE64>` + vmTemplate + `</VMTEMPLATE64></TEMPLATE></MARKETPLACEAPP>`, nil
+		},
+		"one.image.allocate": func(params []string) (interface{}, error) {
+			return 7, nil
+		},
+		"one.template.allocate": func(params []string) (interface{}, error) {
+			return 9, nil
+		},
+	})
+	defer standIn.Close()
+
+	imageId, templateId, err := exportMarketApp(client, 42, "alpine", 1, template.New().Add("PERSISTENT", "NO"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if imageId != "7" || templateId != 9 {
+		t.Errorf("Expected Image 7 and template 9, got %s and %d", imageId, templateId)
+	}
+
+	if len(standIn.Calls) != 3 {
+		t.Fatalf("Expected 3 calls, got %v", standIn.Calls)
+	}
+
+	image := standIn.Calls[1]
+	for _, expected := range []string{`PATH = "http://marketplace/appliance/42/download/0"`, `NAME = "alpine"`, `FROM_APP = "42"`, `PERSISTENT = "NO"`} {
+		if !strings.Contains(image.Params[0], expected) {
+			t.Errorf("Expected the Image template to contain %s, got %s", expected, image.Params[0])
+		}
+	}
+	if image.Params[1] != "1" {
+		t.Errorf("Expected the Image to be allocated in datastore 1, got %s", image.Params[1])
+	}
+
+	allocated, err := template.Parse(standIn.Calls[2].Params[0])
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected, _ := template.Parse("CPU = 1\nMEMORY = 768\nNAME = alpine\nDISK = [ IMAGE_ID = 7 ]")
+	if !allocated.Equal(expected) {
+		t.Errorf("Expected the VM template %s, got %s", expected, allocated)
+	}
+}
+
+func TestExportMarketAppDiscardsImage(t *testing.T) {
+	appTemplate := base64.StdEncoding.EncodeToString([]byte("PATH = \"http://marketplace/appliance/42/download/0\"\n"))
+	vmTemplate := base64.StdEncoding.EncodeToString([]byte("CPU = \"1\"\n"))
+
+	client, standIn := newTestClient(t, map[string]func([]string) (interface{}, error){
+		"one.marketapp.info": func(params []string) (interface{}, error) {
+			return `<MARKETPLACEAPP><ID>42</ID><NAME>Alpine</NAME><TYPE>1</TYPE><STATE>1</STATE>` +
+				`<APPTEMPLATE64>` + appTemplate + `</APPTEMPLATE64>` +
+				`<TEMPLATE><VMTEMPLATE64>` + vmTemplate + `</VMTEMPLATE64></TEMPLATE></MARKETPLACEAPP>`, nil
+		},
+		"one.image.allocate": func(params []string) (interface{}, error) {
+			return 7, nil
+		},
+		"one.template.allocate": func(params []string) (interface{}, error) {
+			return nil, fmt.Errorf("[one.template.allocate] Not authorized to perform CREATE TEMPLATE")
+		},
+		"one.image.delete": func(params []string) (interface{}, error) {
+			return 7, nil
+		},
+	})
+	defer standIn.Close()
+
+	imageId, templateId, err := exportMarketApp(client, 42, "alpine", 1, template.New())
+	if err == nil || !strings.Contains(err.Error(), "CREATE TEMPLATE") {
+		t.Errorf("Expected the error of the template allocation, got %v", err)
+	}
+	if imageId != "" || templateId != -1 {
+		t.Errorf("Expected neither Image nor template to be left, got %q and %d", imageId, templateId)
+	}
+
+	last := standIn.Calls[len(standIn.Calls)-1]
+	if last.Method != "one.image.delete" || last.Params[0] != "7" {
+		t.Errorf("Expected Image 7 to be deleted, got %v", standIn.Calls)
+	}
+}
+
+func TestExportMarketAppRejectsTemplates(t *testing.T) {
+	client, standIn := newTestClient(t, map[string]func([]string) (interface{}, error){
+		"one.marketapp.info": func(params []string) (interface{}, error) {
+			return `<MARKETPLACEAPP><ID>3</ID><TYPE>2</TYPE></MARKETPLACEAPP>`, nil
+		},
+	})
+	defer standIn.Close()
+
+	if _, _, err := exportMarketApp(client, 3, "tmpl", 1, template.New()); err == nil {
+		t.Errorf("Expected exporting a VMTEMPLATE app to fail")
+	}
+}
@@ -0,0 +1,83 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/terraform"
+	"testing"
+)
+
+func TestAccMarket(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckMarketDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccMarketConfigBasic,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_marketplace.test", "name", "test-market"),
+					resource.TestCheckResourceAttr("opennebula_marketplace.test", "permissions", "640"),
+					resource.TestCheckResourceAttr("opennebula_marketplace.test", "market_mad", "http"),
+					resource.TestCheckResourceAttr("opennebula_marketplace.test", "http.0.base_url", "http://frontend/market"),
+					resource.TestCheckResourceAttr("opennebula_marketplace.test", "http.0.public_dir", "/var/local/market"),
+					resource.TestCheckResourceAttr("opennebula_marketplace.test", "app_count", "0"),
+					resource.TestCheckResourceAttrSet("opennebula_marketplace.test", "uid"),
+					resource.TestCheckResourceAttrSet("opennebula_marketplace.test", "gid"),
+					resource.TestCheckResourceAttrSet("opennebula_marketplace.test", "uname"),
+					resource.TestCheckResourceAttrSet("opennebula_marketplace.test", "gname"),
+					resource.TestCheckResourceAttrSet("opennebula_marketplace.test", "total_mb"),
+				),
+			},
+			{
+				Config: testAccMarketConfigUpdate,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_marketplace.test", "name", "test-market-renamed"),
+					resource.TestCheckResourceAttr("opennebula_marketplace.test", "permissions", "600"),
+					resource.TestCheckResourceAttr("opennebula_marketplace.test", "http.0.base_url", "http://frontend/apps"),
+					resource.TestCheckResourceAttr("opennebula_marketplace.test", "http.0.bridge_list", "frontend"),
+				),
+			},
+		},
+	})
+}
+
+func testAccCheckMarketDestroy(s *terraform.State) error {
+	client := testAccProvider.Meta().(*Client)
+
+	for _, rs := range s.RootModule().Resources {
+		_, err := client.Call("one.market.info", intId(rs.Primary.ID))
+		if err == nil {
+			return fmt.Errorf("Expected marketplace %s to have been destroyed", rs.Primary.ID)
+		}
+	}
+
+	return nil
+}
+
+var testAccMarketConfigBasic = `
+resource "opennebula_marketplace" "test" {
+  name = "test-market"
+  permissions = "640"
+  market_mad = "http"
+
+  http {
+    base_url = "http://frontend/market"
+    public_dir = "/var/local/market"
+  }
+}
+`
+
+var testAccMarketConfigUpdate = `
+resource "opennebula_marketplace" "test" {
+  name = "test-market-renamed"
+  permissions = "600"
+  market_mad = "http"
+
+  http {
+    base_url = "http://frontend/apps"
+    public_dir = "/var/local/market"
+    bridge_list = "frontend"
+  }
+}
+`
@@ -0,0 +1,422 @@
+package opennebula
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
+	"github.com/runtastic/terraform-provider-opennebula/opennebula/template"
+	"log"
+	"strconv"
+	"strings"
+)
+
+type SecurityGroup struct {
+	Name        string                 `xml:"NAME"`
+	Id          int                    `xml:"ID"`
+	Uid         int                    `xml:"UID"`
+	Gid         int                    `xml:"GID"`
+	Uname       string                 `xml:"UNAME"`
+	Gname       string                 `xml:"GNAME"`
+	Permissions *Permissions           `xml:"PERMISSIONS"`
+	UpdatedVms  []int                  `xml:"UPDATED_VMS>ID"`
+	OutdatedVms []int                  `xml:"OUTDATED_VMS>ID"`
+	ErrorVms    []int                  `xml:"ERROR_VMS>ID"`
+	Template    *SecurityGroupTemplate `xml:"TEMPLATE"`
+}
+
+type SecurityGroupTemplate struct {
+	Description string               `xml:"DESCRIPTION"`
+	Rules       []*SecurityGroupRule `xml:"RULE"`
+}
+
+type SecurityGroupRule struct {
+	Protocol   string `xml:"PROTOCOL"`
+	RuleType   string `xml:"RULE_TYPE"`
+	Range      string `xml:"RANGE"`
+	Ip         string `xml:"IP"`
+	Size       int    `xml:"SIZE"`
+	NetworkId  string `xml:"NETWORK_ID"`
+	IcmpType   string `xml:"ICMP_TYPE"`
+	Icmpv6Type string `xml:"ICMPv6_TYPE"`
+}
+
+func resourceSecurityGroup() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceSecurityGroupCreate,
+		Read:   resourceSecurityGroupRead,
+		Exists: resourceSecurityGroupExists,
+		Update: resourceSecurityGroupUpdate,
+		Delete: resourceSecurityGroupDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Name of the security group",
+			},
+			"description": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Description of the security group",
+			},
+			"permissions": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Permissions for the security group (in Unix format, owner-group-other, use-manage-admin)",
+				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+					value := v.(string)
+
+					if len(value) != 3 {
+						errors = append(errors, fmt.Errorf("%q has specify 3 permission sets: owner-group-other", k))
+					}
+
+					all := true
+					for _, c := range strings.Split(value, "") {
+						if c < "0" || c > "7" {
+							all = false
+						}
+					}
+					if !all {
+						errors = append(errors, fmt.Errorf("Each character in %q should specify a Unix-like permission set with a number from 0 to 7", k))
+					}
+
+					return
+				},
+			},
+
+			"uid": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Computed:    true,
+				Description: "ID of the user that will own the security group",
+			},
+			"gid": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Computed:    true,
+				Description: "ID of the group that will own the security group",
+			},
+			"uname": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the user that will own the security group",
+			},
+			"gname": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the group that will own the security group",
+			},
+			"rule": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Traffic the security group lets in or out of the VMs",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"rule_type": {
+							Type:         schema.TypeString,
+							Required:     true,
+							Description:  "Direction of the traffic: inbound or outbound",
+							ValidateFunc: validation.StringInSlice([]string{"inbound", "outbound"}, false),
+						},
+						"protocol": {
+							Type:         schema.TypeString,
+							Required:     true,
+							Description:  "Protocol of the traffic: ALL, TCP, UDP, ICMP, ICMPv6 or IPSEC",
+							ValidateFunc: validation.StringInSlice([]string{"ALL", "TCP", "UDP", "ICMP", "ICMPv6", "IPSEC"}, false),
+						},
+						"range": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Ports of the traffic, e.g. 22,80:90. All ports if not set. Only for TCP and UDP",
+						},
+						"ip": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							Description:  "First address of the peers of the traffic. Any address if not set",
+							ValidateFunc: validateIP,
+						},
+						"size": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Description: "Number of addresses from ip",
+						},
+						"network_id": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Default:     -1,
+							Description: "ID of the vnet the peers of the traffic are in",
+						},
+						"icmp_type": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "ICMP type of the traffic. Only for ICMP",
+						},
+						"icmpv6_type": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "ICMPv6 type of the traffic. Only for ICMPv6",
+						},
+					},
+				},
+			},
+			"commit": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     true,
+				Description: "Propagate changes of the rules to the VMs using the security group",
+			},
+			"outdated_vms": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "IDs of the VMs still using a previous version of the rules",
+				Elem:        &schema.Schema{Type: schema.TypeInt},
+			},
+			"error_vms": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "IDs of the VMs the rules couldn't be propagated to",
+				Elem:        &schema.Schema{Type: schema.TypeInt},
+			},
+		},
+	}
+}
+
+// securityGroupRules renders rule blocks as RULE vector attributes
+func securityGroupRules(rules []interface{}) (*template.Template, error) {
+	tmpl := template.New()
+
+	for _, r := range rules {
+		rule := r.(map[string]interface{})
+		protocol := rule["protocol"].(string)
+		vector := template.NewVector("RULE").Add("PROTOCOL", protocol).Add("RULE_TYPE", rule["rule_type"].(string))
+
+		if portRange := rule["range"].(string); portRange != "" {
+			if protocol != "TCP" && protocol != "UDP" {
+				return nil, fmt.Errorf("Only TCP and UDP rules can have a range, not %s ones", protocol)
+			}
+			vector.Add("RANGE", portRange)
+		}
+
+		ip, size := rule["ip"].(string), rule["size"].(int)
+		if (ip == "") != (size == 0) {
+			return nil, fmt.Errorf("Rules need both an ip and a size to filter by address")
+		}
+		if ip != "" {
+			vector.Add("IP", ip).Add("SIZE", size)
+		}
+
+		if networkId := rule["network_id"].(int); networkId >= 0 {
+			vector.Add("NETWORK_ID", networkId)
+		}
+
+		for _, icmp := range []struct {
+			field    string
+			key      string
+			protocol string
+		}{
+			{"icmp_type", "ICMP_TYPE", "ICMP"},
+			{"icmpv6_type", "ICMPv6_TYPE", "ICMPv6"},
+		} {
+			value := rule[icmp.field].(string)
+			if value == "" {
+				continue
+			}
+			if protocol != icmp.protocol {
+				return nil, fmt.Errorf("Only %s rules can have an %s, not %s ones", icmp.protocol, icmp.field, protocol)
+			}
+			vector.Add(icmp.key, value)
+		}
+
+		tmpl.Append(vector)
+	}
+
+	return tmpl, nil
+}
+
+func securityGroupTemplate(d *schema.ResourceData) (*template.Template, error) {
+	rules, err := securityGroupRules(d.Get("rule").([]interface{}))
+	if err != nil {
+		return nil, err
+	}
+
+	return template.New().Add("DESCRIPTION", d.Get("description").(string)).Append(rules.Attributes...), nil
+}
+
+func resourceSecurityGroupCreate(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*Client)
+
+	tmpl, err := securityGroupTemplate(d)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Call(
+		"one.secgroup.allocate",
+		template.New().Add("NAME", d.Get("name").(string)).Append(tmpl.Attributes...).String(),
+	)
+	if err != nil {
+		return err
+	}
+
+	d.SetId(resp)
+
+	if _, err = changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.secgroup.chmod"); err != nil {
+		return err
+	}
+
+	if err = changeOwnership(d, client, "one.secgroup.chown"); err != nil {
+		return err
+	}
+
+	return resourceSecurityGroupRead(d, meta)
+}
+
+func resourceSecurityGroupRead(d *schema.ResourceData, meta interface{}) error {
+	var sg *SecurityGroup
+
+	client := meta.(*Client)
+
+	resp, err := client.Call("one.secgroup.info", intId(d.Id()))
+	if err != nil {
+		if isNotFound(err) {
+			log.Printf("Could not find security group by ID %s", d.Id())
+			d.SetId("")
+			return nil
+		}
+		return err
+	}
+
+	if err = xml.Unmarshal([]byte(resp), &sg); err != nil {
+		return err
+	}
+
+	d.SetId(strconv.Itoa(sg.Id))
+	d.Set("name", sg.Name)
+	d.Set("uid", sg.Uid)
+	d.Set("gid", sg.Gid)
+	d.Set("uname", sg.Uname)
+	d.Set("gname", sg.Gname)
+	d.Set("permissions", permissionString(sg.Permissions))
+	if err = d.Set("outdated_vms", sg.OutdatedVms); err != nil {
+		return err
+	}
+	if err = d.Set("error_vms", sg.ErrorVms); err != nil {
+		return err
+	}
+
+	if sg.Template == nil {
+		return nil
+	}
+
+	rules := make([]map[string]interface{}, 0, len(sg.Template.Rules))
+	for _, rule := range sg.Template.Rules {
+		networkId, err := strconv.Atoi(rule.NetworkId)
+		if err != nil {
+			networkId = -1
+		}
+		rules = append(rules, map[string]interface{}{
+			"rule_type":   strings.ToLower(rule.RuleType),
+			"protocol":    rule.Protocol,
+			"range":       rule.Range,
+			"ip":          rule.Ip,
+			"size":        rule.Size,
+			"network_id":  networkId,
+			"icmp_type":   rule.IcmpType,
+			"icmpv6_type": rule.Icmpv6Type,
+		})
+	}
+
+	d.Set("description", sg.Template.Description)
+	if err = d.Set("rule", rules); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func resourceSecurityGroupExists(d *schema.ResourceData, meta interface{}) (bool, error) {
+	err := resourceSecurityGroupRead(d, meta)
+	if err != nil || d.Id() == "" {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func resourceSecurityGroupUpdate(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*Client)
+
+	if d.HasChange("description") || d.HasChange("rule") {
+		tmpl, err := securityGroupTemplate(d)
+		if err != nil {
+			return err
+		}
+
+		_, err = client.Call(
+			"one.secgroup.update",
+			intId(d.Id()),
+			tmpl.String(),
+			0, // replace the whole security group instead of merging it with the existing one
+		)
+		if err != nil {
+			return err
+		}
+
+		if d.Get("commit").(bool) {
+			// recovery = false: only update the VMs using a previous version of the rules
+			if _, err = client.Call("one.secgroup.commit", intId(d.Id()), false); err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully committed the rules of security group %s to its VMs\n", d.Id())
+		}
+	}
+
+	if d.HasChange("name") {
+		resp, err := client.Call(
+			"one.secgroup.rename",
+			intId(d.Id()),
+			d.Get("name").(string),
+		)
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated name for security group %s\n", resp)
+	}
+
+	if d.HasChange("permissions") {
+		resp, err := changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.secgroup.chmod")
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated security group %s\n", resp)
+	}
+
+	if d.HasChange("uid") || d.HasChange("gid") {
+		if err := changeOwnership(d, client, "one.secgroup.chown"); err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated owner of security group %s\n", d.Id())
+	}
+
+	return nil
+}
+
+func resourceSecurityGroupDelete(d *schema.ResourceData, meta interface{}) error {
+	err := resourceSecurityGroupRead(d, meta)
+	if err != nil || d.Id() == "" {
+		return err
+	}
+
+	client := meta.(*Client)
+	resp, err := client.Call("one.secgroup.delete", intId(d.Id()))
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Successfully deleted security group %s\n", resp)
+	return nil
+}
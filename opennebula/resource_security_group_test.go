@@ -0,0 +1,151 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/terraform"
+	"testing"
+)
+
+func TestAccSecurityGroup(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckSecurityGroupDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccSecurityGroupConfigBasic,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_security_group.test", "name", "test-secgroup"),
+					resource.TestCheckResourceAttr("opennebula_security_group.test", "permissions", "642"),
+					resource.TestCheckResourceAttr("opennebula_security_group.test", "rule.#", "2"),
+					resource.TestCheckResourceAttr("opennebula_security_group.test", "rule.0.rule_type", "inbound"),
+					resource.TestCheckResourceAttr("opennebula_security_group.test", "rule.0.range", "22,80:90"),
+					resource.TestCheckResourceAttr("opennebula_security_group.test", "rule.1.protocol", "ALL"),
+					resource.TestCheckResourceAttrSet("opennebula_security_group.test", "uname"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "security_groups.#", "1"),
+				),
+			},
+			{
+				Config: testAccSecurityGroupConfigUpdate,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_security_group.test", "name", "test-secgroup-renamed"),
+					resource.TestCheckResourceAttr("opennebula_security_group.test", "rule.#", "3"),
+					resource.TestCheckResourceAttr("opennebula_security_group.test", "rule.2.icmp_type", "8"),
+				),
+			},
+		},
+	})
+}
+
+func TestSecurityGroupRules(t *testing.T) {
+	rule := func(protocol, portRange, ip string, size int, icmpType string) map[string]interface{} {
+		return map[string]interface{}{
+			"rule_type":   "inbound",
+			"protocol":    protocol,
+			"range":       portRange,
+			"ip":          ip,
+			"size":        size,
+			"network_id":  -1,
+			"icmp_type":   icmpType,
+			"icmpv6_type": "",
+		}
+	}
+
+	tmpl, err := securityGroupRules([]interface{}{rule("TCP", "22", "10.0.0.0", 256, "")})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := "RULE = [\n  PROTOCOL = \"TCP\",\n  RULE_TYPE = \"inbound\",\n  RANGE = \"22\",\n  IP = \"10.0.0.0\",\n  SIZE = \"256\" ]\n"
+	if tmpl.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, tmpl)
+	}
+
+	for _, invalid := range []map[string]interface{}{
+		rule("ICMP", "22", "", 0, ""),
+		rule("TCP", "", "", 0, "8"),
+		rule("TCP", "", "10.0.0.0", 0, ""),
+	} {
+		if tmpl, err := securityGroupRules([]interface{}{invalid}); err == nil {
+			t.Errorf("Expected %v to be invalid, got %s", invalid, tmpl)
+		}
+	}
+}
+
+func testAccCheckSecurityGroupDestroy(s *terraform.State) error {
+	client := testAccProvider.Meta().(*Client)
+
+	for _, rs := range s.RootModule().Resources {
+		method := "one.secgroup.info"
+		if rs.Type == "opennebula_vnet" {
+			method = "one.vn.info"
+		}
+		if _, err := client.Call(method, intId(rs.Primary.ID)); err == nil {
+			return fmt.Errorf("Expected %s %s to have been destroyed", rs.Type, rs.Primary.ID)
+		}
+	}
+
+	return nil
+}
+
+var testAccSecurityGroupConfigBasic = `
+resource "opennebula_security_group" "test" {
+  name = "test-secgroup"
+  description = "Web servers"
+  permissions = "642"
+
+  rule {
+    rule_type = "inbound"
+    protocol = "TCP"
+    range = "22,80:90"
+  }
+
+  rule {
+    rule_type = "outbound"
+    protocol = "ALL"
+  }
+}
+
+resource "opennebula_vnet" "test" {
+  name = "test-secgroup-vnet"
+  description = ""
+  bridge = "br-test"
+  permissions = "642"
+  vn_mad = "dummy"
+  security_groups = ["${opennebula_security_group.test.id}"]
+}
+`
+
+var testAccSecurityGroupConfigUpdate = `
+resource "opennebula_security_group" "test" {
+  name = "test-secgroup-renamed"
+  description = "Web servers"
+  permissions = "642"
+
+  rule {
+    rule_type = "inbound"
+    protocol = "TCP"
+    range = "22,80:90"
+  }
+
+  rule {
+    rule_type = "outbound"
+    protocol = "ALL"
+  }
+
+  rule {
+    rule_type = "inbound"
+    protocol = "ICMP"
+    icmp_type = "8"
+  }
+}
+
+resource "opennebula_vnet" "test" {
+  name = "test-secgroup-vnet"
+  description = ""
+  bridge = "br-test"
+  permissions = "642"
+  vn_mad = "dummy"
+  security_groups = ["${opennebula_security_group.test.id}"]
+}
+`
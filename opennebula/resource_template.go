@@ -4,6 +4,8 @@ import (
 	"encoding/xml"
 	"fmt"
 	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
+	"github.com/runtastic/terraform-provider-opennebula/opennebula/template"
 	"log"
 	"strconv"
 	"strings"
@@ -22,6 +24,7 @@ type UserTemplate struct {
 	Gname       string       `xml:"GNAME"`
 	RegTime     int          `xml:"REGTIME"`
 	Permissions *Permissions `xml:"PERMISSIONS"`
+	Lock        *Lock        `xml:"LOCK"`
 }
 
 func resourceTemplate() *schema.Resource {
@@ -42,9 +45,11 @@ func resourceTemplate() *schema.Resource {
 				Description: "Name of the template",
 			},
 			"description": {
-				Type:        schema.TypeString,
-				Required:    true,
-				Description: "Description of the template, in OpenNebula's XML or String format",
+				Type:             schema.TypeString,
+				Required:         true,
+				Description:      "Description of the template, in OpenNebula's XML or String format",
+				ValidateFunc:     validateTemplate,
+				DiffSuppressFunc: suppressEquivalentTemplates,
 			},
 			"permissions": {
 				Type:        schema.TypeString,
@@ -96,6 +101,18 @@ func resourceTemplate() *schema.Resource {
 				Computed:    true,
 				Description: "Registration time",
 			},
+			"lock": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "Lock level of the template: USE, MANAGE, ADMIN or ALL. Locked templates can't be destroyed",
+				ValidateFunc: validation.StringInSlice([]string{"USE", "MANAGE", "ADMIN", "ALL"}, false),
+			},
+			"adopt_by_name": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "If the template can't be found by ID, adopt the only template of the user with the same name",
+			},
 		},
 	}
 }
@@ -103,9 +120,14 @@ func resourceTemplate() *schema.Resource {
 func resourceTemplateCreate(d *schema.ResourceData, meta interface{}) error {
 	client := meta.(*Client)
 
+	description, err := template.Parse(d.Get("description").(string))
+	if err != nil {
+		return err
+	}
+
 	resp, err := client.Call(
 		"one.template.allocate",
-		fmt.Sprintf("NAME = \"%s\"\n", d.Get("name").(string))+d.Get("description").(string),
+		template.New().Add("NAME", d.Get("name").(string)).Append(description.Attributes...).String(),
 	)
 	if err != nil {
 		return err
@@ -117,53 +139,50 @@ func resourceTemplateCreate(d *schema.ResourceData, meta interface{}) error {
 		return err
 	}
 
+	if err = changeLock(intId(d.Id()), "", d.Get("lock").(string), client, "one.template"); err != nil {
+		return err
+	}
+
 	return resourceTemplateRead(d, meta)
 }
 
 func resourceTemplateRead(d *schema.ResourceData, meta interface{}) error {
 	var tmpl *UserTemplate
-	var tmpls *UserTemplates
 
 	client := meta.(*Client)
-	found := false
 
 	// Try to find the template by ID, if specified
 	if d.Id() != "" {
 		resp, err := client.Call("one.template.info", intId(d.Id()), false)
 		if err == nil {
-			found = true
 			if err = xml.Unmarshal([]byte(resp), &tmpl); err != nil {
 				return err
 			}
+		} else if !isNotFound(err) {
+			return err
 		} else {
 			log.Printf("Could not find template by ID %s", d.Id())
 		}
 	}
 
-	// Otherwise, try to find the template by (user, name) as the de facto compound primary key
-	if d.Id() == "" || !found {
-		resp, err := client.Call("one.templatepool.info", -3, -1, -1)
-		if err != nil {
-			return err
+	// Otherwise, only adopt a template by (user, name) as the de facto compound primary key if asked to
+	if tmpl == nil {
+		if !d.Get("adopt_by_name").(bool) {
+			d.SetId("")
+			return nil
 		}
 
-		if err = xml.Unmarshal([]byte(resp), &tmpls); err != nil {
+		found, err := findTemplateByName(client, d.Get("name").(string))
+		if err != nil {
 			return err
 		}
 
-		for _, t := range tmpls.UserTemplate {
-			if t.Name == d.Get("name").(string) {
-				tmpl = t
-				found = true
-				break
-			}
-		}
-
-		if !found || tmpl == nil {
+		if found == nil {
 			d.SetId("")
 			log.Printf("Could not find template with name %s for user %s", d.Get("name").(string), client.Username)
 			return nil
 		}
+		tmpl = found
 	}
 
 	d.SetId(strconv.Itoa(tmpl.Id))
@@ -174,10 +193,39 @@ func resourceTemplateRead(d *schema.ResourceData, meta interface{}) error {
 	d.Set("gname", tmpl.Gname)
 	d.Set("reg_time", tmpl.RegTime)
 	d.Set("permissions", permissionString(tmpl.Permissions))
+	d.Set("lock", lockLevelName(tmpl.Lock))
 
 	return nil
 }
 
+// findTemplateByName looks for a single template with the given name that is owned by the user.
+// It returns nil if there is no such template and fails if the name is ambiguous.
+func findTemplateByName(client *Client, name string) (*UserTemplate, error) {
+	var tmpls *UserTemplates
+	var tmpl *UserTemplate
+
+	resp, err := client.Call("one.templatepool.info", -3, -1, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = xml.Unmarshal([]byte(resp), &tmpls); err != nil {
+		return nil, err
+	}
+
+	for _, t := range tmpls.UserTemplate {
+		if t.Name != name || t.Uname != client.Username {
+			continue
+		}
+		if tmpl != nil {
+			return nil, fmt.Errorf("Found more than one template with name %s for user %s (IDs %d and %d)", name, client.Username, tmpl.Id, t.Id)
+		}
+		tmpl = t
+	}
+
+	return tmpl, nil
+}
+
 func resourceTemplateExists(d *schema.ResourceData, meta interface{}) (bool, error) {
 	err := resourceTemplateRead(d, meta)
 	if err != nil || d.Id() == "" {
@@ -187,9 +235,14 @@ func resourceTemplateExists(d *schema.ResourceData, meta interface{}) (bool, err
 	return true, nil
 }
 
-func resourceTemplateUpdate(d *schema.ResourceData, meta interface{}) error {
+func resourceTemplateUpdate(d *schema.ResourceData, meta interface{}) (err error) {
 	client := meta.(*Client)
 
+	if err = unlockForUpdate(d, client, "one.template"); err != nil {
+		return err
+	}
+	defer relockAfterUpdate(d, client, "one.template", &err)
+
 	if d.HasChange("name") {
 		resp, err := client.Call(
 			"one.template.rename",
@@ -231,6 +284,10 @@ func resourceTemplateDelete(d *schema.ResourceData, meta interface{}) error {
 		return err
 	}
 
+	if err = checkUnlocked("Template", d.Id(), d.Get("lock").(string)); err != nil {
+		return err
+	}
+
 	client := meta.(*Client)
 	resp, err := client.Call("one.template.delete", intId(d.Id()), false)
 	if err != nil {
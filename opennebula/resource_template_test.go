@@ -46,6 +46,18 @@ func TestAccTemplate(t *testing.T) {
 					}),
 				),
 			},
+			{
+				Config: testAccTemplateConfigLocked,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_template.test", "lock", "MANAGE"),
+				),
+			},
+			{
+				Config: testAccTemplateConfigUpdate,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_template.test", "lock", ""),
+				),
+			},
 		},
 	})
 }
@@ -133,3 +145,15 @@ resource "opennebula_template" "test" {
   permissions = "600"
 }
 `
+
+var testAccTemplateConfigLocked = `
+resource "opennebula_template" "test" {
+  name = "test-me"
+  description = <<EOF
+	FOO = "bar"
+	BAR = "foo"
+  EOF
+  permissions = "600"
+  lock = "MANAGE"
+}
+`
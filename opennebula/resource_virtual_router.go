@@ -0,0 +1,654 @@
+package opennebula
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/runtastic/terraform-provider-opennebula/opennebula/template"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type VirtualRouter struct {
+	Name        string                 `xml:"NAME"`
+	Id          int                    `xml:"ID"`
+	Uid         int                    `xml:"UID"`
+	Gid         int                    `xml:"GID"`
+	Uname       string                 `xml:"UNAME"`
+	Gname       string                 `xml:"GNAME"`
+	Permissions *Permissions           `xml:"PERMISSIONS"`
+	VmIds       []int                  `xml:"VMS>ID"`
+	Template    *VirtualRouterTemplate `xml:"TEMPLATE"`
+}
+
+type VirtualRouterTemplate struct {
+	Description  string              `xml:"DESCRIPTION"`
+	KeepalivedId string              `xml:"KEEPALIVED_ID"`
+	NICs         []*VirtualRouterNic `xml:"NIC"`
+}
+
+type VirtualRouterNic struct {
+	NicId      int    `xml:"NIC_ID"`
+	NetworkId  int    `xml:"NETWORK_ID"`
+	Ip         string `xml:"IP"`
+	FloatingIp string `xml:"FLOATING_IP"`
+}
+
+func resourceVirtualRouter() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceVirtualRouterCreate,
+		Read:   resourceVirtualRouterRead,
+		Exists: resourceVirtualRouterExists,
+		Update: resourceVirtualRouterUpdate,
+		Delete: resourceVirtualRouterDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Name of the virtual router",
+			},
+			"description": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Description of the virtual router",
+			},
+			"permissions": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Permissions for the virtual router (in Unix format, owner-group-other, use-manage-admin)",
+				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+					value := v.(string)
+
+					if len(value) != 3 {
+						errors = append(errors, fmt.Errorf("%q has specify 3 permission sets: owner-group-other", k))
+					}
+
+					all := true
+					for _, c := range strings.Split(value, "") {
+						if c < "0" || c > "7" {
+							all = false
+						}
+					}
+					if !all {
+						errors = append(errors, fmt.Errorf("Each character in %q should specify a Unix-like permission set with a number from 0 to 7", k))
+					}
+
+					return
+				},
+			},
+
+			"uid": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Computed:    true,
+				Description: "ID of the user that will own the virtual router",
+			},
+			"gid": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Computed:    true,
+				Description: "ID of the group that will own the virtual router",
+			},
+			"uname": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the user that will own the virtual router",
+			},
+			"gname": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the group that will own the virtual router",
+			},
+			"template_id": {
+				Type:        schema.TypeInt,
+				Required:    true,
+				ForceNew:    true,
+				Description: "ID of the VM template to instantiate the VMs of the virtual router from",
+			},
+			"instances": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				ForceNew:    true,
+				Default:     1,
+				Description: "Number of VMs of the virtual router. More than one runs them in high availability with keepalived",
+			},
+			"keepalived_id": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				Description: "Virtual router ID used by keepalived among the VMs of the virtual router",
+			},
+			"keepalived_password": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Sensitive:   true,
+				Description: "Password used by keepalived among the VMs of the virtual router",
+			},
+			"nic": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "NICs of the virtual router, attached to each of its VMs",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"nic_id": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "ID of the NIC within the virtual router",
+						},
+						"network_id": {
+							Type:        schema.TypeInt,
+							Required:    true,
+							Description: "ID of the vnet the NIC is attached to",
+						},
+						"floating_ip": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							Description: "Whether the virtual router holds a floating IP in the vnet, which moves between its VMs",
+						},
+						"ip": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							Computed:     true,
+							Description:  "Floating IP of the NIC. OpenNebula picks one from the vnet if not set",
+							ValidateFunc: validateIP,
+						},
+					},
+				},
+			},
+			"vm_ids": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "IDs of the VMs of the virtual router",
+				Elem:        &schema.Schema{Type: schema.TypeInt},
+			},
+		},
+	}
+}
+
+// virtualRouterNic renders a nic block as a NIC vector attribute
+func virtualRouterNic(nic map[string]interface{}) (*template.Attribute, error) {
+	vector := template.NewVector("NIC").Add("NETWORK_ID", nic["network_id"].(int))
+
+	if nic["floating_ip"].(bool) {
+		vector.Add("FLOATING_IP", "YES")
+		if ip := nic["ip"].(string); ip != "" {
+			vector.Add("IP", ip)
+		}
+	} else if nic["ip"].(string) != "" {
+		return nil, fmt.Errorf("Only NICs with a floating_ip can have an ip, the ones of the VMs are leased by OpenNebula")
+	}
+
+	return vector, nil
+}
+
+func virtualRouterTemplate(d *schema.ResourceData) (*template.Template, error) {
+	tmpl := template.New().Add("NAME", d.Get("name").(string))
+	if description, ok := d.GetOk("description"); ok {
+		tmpl.Add("DESCRIPTION", description.(string))
+	}
+	if id, ok := d.GetOk("keepalived_id"); ok {
+		tmpl.Add("KEEPALIVED_ID", id.(string))
+	}
+	if password, ok := d.GetOk("keepalived_password"); ok {
+		tmpl.Add("KEEPALIVED_PASSWORD", password.(string))
+	}
+
+	for _, n := range d.Get("nic").([]interface{}) {
+		nic, err := virtualRouterNic(n.(map[string]interface{}))
+		if err != nil {
+			return nil, err
+		}
+		tmpl.Append(nic)
+	}
+
+	return tmpl, nil
+}
+
+func resourceVirtualRouterCreate(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*Client)
+
+	tmpl, err := virtualRouterTemplate(d)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Call("one.vrouter.allocate", tmpl.String())
+	if err != nil {
+		return err
+	}
+
+	d.SetId(resp)
+
+	if _, err = changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.vrouter.chmod"); err != nil {
+		return err
+	}
+
+	if err = changeOwnership(d, client, "one.vrouter.chown"); err != nil {
+		return err
+	}
+
+	// an empty name lets OpenNebula name the VMs after the virtual router; hold = false
+	_, err = client.Call(
+		"one.vrouter.instantiate",
+		intId(d.Id()),
+		d.Get("instances").(int),
+		d.Get("template_id").(int),
+		"",
+		false,
+		"",
+	)
+	if err != nil {
+		return err
+	}
+
+	if err = waitForVirtualRouterVms(d, client, "running"); err != nil {
+		return err
+	}
+
+	return resourceVirtualRouterRead(d, meta)
+}
+
+// waitForVirtualRouterVms waits for every VM of the virtual router to be running or done, as in state
+func waitForVirtualRouterVms(d *schema.ResourceData, client *Client, state string) error {
+	vr, err := virtualRouterInfo(client, d.Id())
+	if err != nil {
+		return err
+	}
+
+	for _, id := range vr.VmIds {
+		if _, err = waitForVm(client, strconv.Itoa(id), state); err != nil {
+			return fmt.Errorf("Error waiting for VM %d of virtual router %s to be %s: %s", id, d.Id(), state, err)
+		}
+	}
+
+	return nil
+}
+
+func virtualRouterInfo(client *Client, id string) (*VirtualRouter, error) {
+	var vr *VirtualRouter
+
+	resp, err := client.Call("one.vrouter.info", intId(id))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = xml.Unmarshal([]byte(resp), &vr); err != nil {
+		return nil, err
+	}
+
+	return vr, nil
+}
+
+func resourceVirtualRouterRead(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*Client)
+
+	vr, err := virtualRouterInfo(client, d.Id())
+	if err != nil {
+		if isNotFound(err) {
+			log.Printf("Could not find virtual router by ID %s", d.Id())
+			d.SetId("")
+			return nil
+		}
+		return err
+	}
+
+	d.SetId(strconv.Itoa(vr.Id))
+	d.Set("name", vr.Name)
+	d.Set("uid", vr.Uid)
+	d.Set("gid", vr.Gid)
+	d.Set("uname", vr.Uname)
+	d.Set("gname", vr.Gname)
+	d.Set("permissions", permissionString(vr.Permissions))
+	if err = d.Set("vm_ids", vr.VmIds); err != nil {
+		return err
+	}
+
+	// the router itself doesn't keep how it was instantiated, its VMs do
+	d.Set("instances", len(vr.VmIds))
+	templateId, err := virtualRouterTemplateId(client, vr)
+	if err != nil {
+		return err
+	}
+	if templateId >= 0 {
+		d.Set("template_id", templateId)
+	}
+
+	if vr.Template == nil {
+		return nil
+	}
+
+	d.Set("description", vr.Template.Description)
+	d.Set("keepalived_id", vr.Template.KeepalivedId)
+
+	if err = d.Set("nic", virtualRouterNics(vr, d.Get("nic").([]interface{}))); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// virtualRouterTemplateId returns the ID of the VM template the VMs of a virtual router were instantiated from,
+// or -1 if the router has no VMs to tell
+func virtualRouterTemplateId(client *Client, vr *VirtualRouter) (int, error) {
+	for _, id := range vr.VmIds {
+		vm, err := findVmById(client, strconv.Itoa(id))
+		if err != nil {
+			return -1, err
+		}
+		if vm != nil && vm.VmTemplate != nil {
+			return vm.VmTemplate.TemplateId, nil
+		}
+	}
+
+	return -1, nil
+}
+
+// virtualRouterNics lists the NICs of a virtual router in the order of the known ones, matched by ID, as
+// OpenNebula lists them by ID instead. The NICs that aren't known yet come last
+func virtualRouterNics(vr *VirtualRouter, known []interface{}) []map[string]interface{} {
+	byId := make(map[int]*VirtualRouterNic)
+	for _, nic := range vr.Template.NICs {
+		byId[nic.NicId] = nic
+	}
+
+	ordered := make([]*VirtualRouterNic, 0, len(vr.Template.NICs))
+	for _, k := range known {
+		if k == nil {
+			continue
+		}
+		if nic, ok := byId[k.(map[string]interface{})["nic_id"].(int)]; ok {
+			ordered = append(ordered, nic)
+			delete(byId, nic.NicId)
+		}
+	}
+	for _, nic := range vr.Template.NICs {
+		if _, ok := byId[nic.NicId]; ok {
+			ordered = append(ordered, nic)
+		}
+	}
+
+	nics := make([]map[string]interface{}, 0, len(ordered))
+	for _, nic := range ordered {
+		nics = append(nics, map[string]interface{}{
+			"nic_id":      nic.NicId,
+			"network_id":  nic.NetworkId,
+			"floating_ip": strings.ToUpper(nic.FloatingIp) == "YES",
+			"ip":          nic.Ip,
+		})
+	}
+
+	return nics
+}
+
+func resourceVirtualRouterExists(d *schema.ResourceData, meta interface{}) (bool, error) {
+	err := resourceVirtualRouterRead(d, meta)
+	if err != nil || d.Id() == "" {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// virtualRouterNicKept tells whether the NIC prev already is the nic block, with ip the floating IP it asks for
+func virtualRouterNicKept(prev, nic map[string]interface{}, ip string) bool {
+	return prev["network_id"] == nic["network_id"] && prev["floating_ip"] == nic["floating_ip"] &&
+		(ip == "" || prev["ip"] == ip)
+}
+
+// matchVirtualRouterNics pairs each NIC of new with the NIC of old it keeps, or -1 if it is a new NIC. NICs are
+// matched preferably at the same position, so that removing or replacing a NIC doesn't move the ones after it.
+// Away from their position, the ip of new may be the one carried over from the NIC that was there before, so
+// it only takes part in the match if it differs from that one
+func matchVirtualRouterNics(old, new []interface{}) []int {
+	matches := make([]int, len(new))
+	matched := make(map[int]bool)
+
+	for i, n := range new {
+		matches[i] = -1
+		nic := n.(map[string]interface{})
+		if i < len(old) && virtualRouterNicKept(old[i].(map[string]interface{}), nic, nic["ip"].(string)) {
+			matches[i] = i
+			matched[i] = true
+		}
+	}
+
+	for i, n := range new {
+		if matches[i] >= 0 {
+			continue
+		}
+		nic := n.(map[string]interface{})
+		ip := nic["ip"].(string)
+		if i < len(old) && old[i].(map[string]interface{})["ip"] == ip {
+			ip = ""
+		}
+		for j, o := range old {
+			if !matched[j] && virtualRouterNicKept(o.(map[string]interface{}), nic, ip) {
+				matches[i] = j
+				matched[j] = true
+				break
+			}
+		}
+	}
+
+	return matches
+}
+
+// updateVirtualRouterNics reconciles the NICs of a virtual router one by one and returns the NICs of the
+// configuration with their IDs in OpenNebula. NICs can't be changed in place, so changed ones are replaced
+func updateVirtualRouterNics(client *Client, id int, old, new []interface{}) ([]interface{}, error) {
+	matches := matchVirtualRouterNics(old, new)
+	detached := make([]int, 0)
+	attached := make([]int, 0)
+
+	kept := make(map[int]bool)
+	for _, j := range matches {
+		if j >= 0 {
+			kept[j] = true
+		}
+	}
+
+	// render the new NICs before touching the virtual router, so that invalid ones don't leave it half updated
+	templates := make([]string, len(new))
+	for i, n := range new {
+		if matches[i] >= 0 {
+			continue
+		}
+
+		// a NIC attached where another one was before carries over its ip, which is only free if that NIC
+		// goes and was in the same vnet
+		nic := n.(map[string]interface{})
+		if i < len(old) {
+			prev := old[i].(map[string]interface{})
+			if nic["ip"] == prev["ip"] && (kept[i] || prev["network_id"] != nic["network_id"] || !nic["floating_ip"].(bool)) {
+				nic = copyNic(nic)
+				nic["ip"] = ""
+			}
+		}
+
+		tmpl, err := virtualRouterNic(nic)
+		if err != nil {
+			return nil, err
+		}
+		templates[i] = tmpl.String()
+		attached = append(attached, i)
+	}
+
+	for j, o := range old {
+		if !kept[j] {
+			detached = append(detached, o.(map[string]interface{})["nic_id"].(int))
+		}
+	}
+
+	for _, nicId := range detached {
+		if _, err := client.Call("one.vrouter.detachnic", id, nicId); err != nil {
+			return nil, err
+		}
+		log.Printf("[INFO] Successfully detached NIC %d from virtual router %d\n", nicId, id)
+	}
+
+	for _, i := range attached {
+		if _, err := client.Call("one.vrouter.attachnic", id, templates[i]); err != nil {
+			return nil, err
+		}
+		log.Printf("[INFO] Successfully attached NIC to virtual router %d\n", id)
+	}
+
+	// the NICs kept have the ID and IP they had, the ones attached get the ones OpenNebula gave them
+	nics := make([]interface{}, len(new))
+	for i, n := range new {
+		if j := matches[i]; j >= 0 {
+			prev := old[j].(map[string]interface{})
+			nic := copyNic(n.(map[string]interface{}))
+			nic["nic_id"] = prev["nic_id"]
+			nic["ip"] = prev["ip"]
+			nics[i] = nic
+		}
+	}
+
+	if len(attached) > 0 {
+		attachedNics, err := attachedVirtualRouterNics(client, id, old, detached, len(attached))
+		if err != nil {
+			return nil, err
+		}
+		for k, i := range attached {
+			nic := copyNic(new[i].(map[string]interface{}))
+			nic["nic_id"] = attachedNics[k].NicId
+			nic["ip"] = attachedNics[k].Ip
+			nics[i] = nic
+		}
+	}
+
+	return nics, nil
+}
+
+// attachedVirtualRouterNics reads back the count NICs just attached to a virtual router, in the order they were
+// attached, as one.vrouter.attachnic doesn't return their IDs. OpenNebula gives each new NIC a higher ID
+func attachedVirtualRouterNics(client *Client, id int, old []interface{}, detached []int, count int) ([]*VirtualRouterNic, error) {
+	vr, err := virtualRouterInfo(client, strconv.Itoa(id))
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make(map[int]bool)
+	for _, o := range old {
+		existing[o.(map[string]interface{})["nic_id"].(int)] = true
+	}
+	for _, nicId := range detached {
+		delete(existing, nicId)
+	}
+
+	attached := make([]*VirtualRouterNic, 0, count)
+	if vr.Template != nil {
+		for _, nic := range vr.Template.NICs {
+			if !existing[nic.NicId] {
+				attached = append(attached, nic)
+			}
+		}
+	}
+	if len(attached) != count {
+		return nil, fmt.Errorf("Expected %d new NICs in virtual router %d, found %d", count, id, len(attached))
+	}
+	sort.Slice(attached, func(i, j int) bool { return attached[i].NicId < attached[j].NicId })
+
+	return attached, nil
+}
+
+// copyNic is a copy of the nic block nic, to be changed
+func copyNic(nic map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(nic))
+	for k, v := range nic {
+		copied[k] = v
+	}
+
+	return copied
+}
+
+func resourceVirtualRouterUpdate(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*Client)
+
+	if d.HasChange("name") {
+		resp, err := client.Call(
+			"one.vrouter.rename",
+			intId(d.Id()),
+			d.Get("name").(string),
+		)
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated name for virtual router %s\n", resp)
+	}
+
+	if d.HasChange("description") {
+		_, err := client.Call(
+			"one.vrouter.update",
+			intId(d.Id()),
+			template.New().Add("DESCRIPTION", d.Get("description").(string)).String(),
+			1, // merge with the existing virtual router, which holds its NICs as well
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if d.HasChange("nic") {
+		old, new := d.GetChange("nic")
+		nics, err := updateVirtualRouterNics(client, intId(d.Id()), old.([]interface{}), new.([]interface{}))
+		if err != nil {
+			return err
+		}
+		if err = d.Set("nic", nics); err != nil {
+			return err
+		}
+	}
+
+	if d.HasChange("permissions") {
+		resp, err := changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.vrouter.chmod")
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated virtual router %s\n", resp)
+	}
+
+	if d.HasChange("uid") || d.HasChange("gid") {
+		if err := changeOwnership(d, client, "one.vrouter.chown"); err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated owner of virtual router %s\n", d.Id())
+	}
+
+	return nil
+}
+
+func resourceVirtualRouterDelete(d *schema.ResourceData, meta interface{}) error {
+	err := resourceVirtualRouterRead(d, meta)
+	if err != nil || d.Id() == "" {
+		return err
+	}
+
+	vmIds := d.Get("vm_ids").([]interface{})
+
+	// deleting the virtual router terminates its VMs as well
+	client := meta.(*Client)
+	resp, err := client.Call("one.vrouter.delete", intId(d.Id()))
+	if err != nil {
+		return err
+	}
+
+	for _, id := range vmIds {
+		if _, err = waitForVm(client, strconv.Itoa(id.(int)), "done"); err != nil {
+			return fmt.Errorf("Error waiting for VM %d of virtual router %s to be done: %s", id.(int), d.Id(), err)
+		}
+	}
+
+	log.Printf("[INFO] Successfully deleted virtual router %s\n", resp)
+	return nil
+}
@@ -0,0 +1,295 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/terraform"
+	"reflect"
+	"testing"
+)
+
+func TestAccVirtualRouter(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVirtualRouterDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccVirtualRouterConfigBasic,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_virtual_router.test", "name", "test-vrouter"),
+					resource.TestCheckResourceAttr("opennebula_virtual_router.test", "permissions", "642"),
+					resource.TestCheckResourceAttr("opennebula_virtual_router.test", "vm_ids.#", "2"),
+					resource.TestCheckResourceAttr("opennebula_virtual_router.test", "nic.#", "1"),
+					resource.TestCheckResourceAttr("opennebula_virtual_router.test", "nic.0.floating_ip", "true"),
+					resource.TestCheckResourceAttr("opennebula_virtual_router.test", "nic.0.ip", "192.168.0.10"),
+					resource.TestCheckResourceAttrSet("opennebula_virtual_router.test", "keepalived_id"),
+					resource.TestCheckResourceAttrSet("opennebula_virtual_router.test", "uname"),
+				),
+			},
+			{
+				Config: testAccVirtualRouterConfigUpdate,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_virtual_router.test", "name", "test-vrouter-renamed"),
+					resource.TestCheckResourceAttr("opennebula_virtual_router.test", "description", "Edge router"),
+					resource.TestCheckResourceAttr("opennebula_virtual_router.test", "permissions", "600"),
+					resource.TestCheckResourceAttr("opennebula_virtual_router.test", "nic.#", "2"),
+					resource.TestCheckResourceAttr("opennebula_virtual_router.test", "nic.1.floating_ip", "false"),
+				),
+			},
+			{
+				ResourceName:            "opennebula_virtual_router.test",
+				ImportState:             true,
+				ImportStateVerify:       true,
+				ImportStateVerifyIgnore: []string{"keepalived_password"},
+			},
+		},
+	})
+}
+
+func TestVirtualRouterTemplateId(t *testing.T) {
+	client, standIn := newTestClient(t, map[string]func([]string) (interface{}, error){
+		"one.vm.info": func(params []string) (interface{}, error) {
+			if params[0] == "10" {
+				return nil, &Error{Code: ErrNoExists, Message: "[one.vm.info] Error getting virtual machine [10]."}
+			}
+			return fmt.Sprintf("<VM><ID>%s</ID><TEMPLATE><TEMPLATE_ID>4</TEMPLATE_ID></TEMPLATE></VM>", params[0]), nil
+		},
+	})
+	defer standIn.Close()
+
+	// a VM that is gone meanwhile can't tell, the next one can
+	if templateId, err := virtualRouterTemplateId(client, &VirtualRouter{VmIds: []int{10, 11}}); err != nil || templateId != 4 {
+		t.Errorf("Expected template 4, got %d (err: %v)", templateId, err)
+	}
+	if templateId, err := virtualRouterTemplateId(client, &VirtualRouter{}); err != nil || templateId != -1 {
+		t.Errorf("Expected no template for a router without VMs, got %d (err: %v)", templateId, err)
+	}
+}
+
+func TestUpdateVirtualRouterNics(t *testing.T) {
+	info := "<VROUTER><ID>7</ID><TEMPLATE><NIC><NIC_ID>0</NIC_ID><NETWORK_ID>1</NETWORK_ID><FLOATING_IP>YES</FLOATING_IP><IP>10.0.0.1</IP></NIC>" +
+		"<NIC><NIC_ID>3</NIC_ID><NETWORK_ID>4</NETWORK_ID><FLOATING_IP>YES</FLOATING_IP><IP>10.0.4.1</IP></NIC></TEMPLATE></VROUTER>"
+
+	client, standIn := newTestClient(t, map[string]func([]string) (interface{}, error){
+		"one.vrouter.info":      func(params []string) (interface{}, error) { return info, nil },
+		"one.vrouter.detachnic": func(params []string) (interface{}, error) { return 7, nil },
+		"one.vrouter.attachnic": func(params []string) (interface{}, error) { return 7, nil },
+	})
+	defer standIn.Close()
+
+	nic := func(nicId, networkId int, floating bool, ip string) map[string]interface{} {
+		return map[string]interface{}{
+			"nic_id":      nicId,
+			"network_id":  networkId,
+			"floating_ip": floating,
+			"ip":          ip,
+		}
+	}
+
+	old := []interface{}{
+		nic(0, 1, true, "10.0.0.1"),
+		nic(1, 2, false, ""),
+		nic(2, 3, false, ""),
+	}
+	new := []interface{}{
+		nic(0, 1, true, ""),
+		nic(0, 4, true, "10.0.4.1"),
+	}
+
+	nics, err := updateVirtualRouterNics(client, 7, old, new)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []xmlRpcCall{
+		{Method: "one.vrouter.detachnic", Params: []string{"7", "1"}},
+		{Method: "one.vrouter.detachnic", Params: []string{"7", "2"}},
+		{Method: "one.vrouter.attachnic", Params: []string{"7", "NIC = [\n  NETWORK_ID = \"4\",\n  FLOATING_IP = \"YES\",\n  IP = \"10.0.4.1\" ]"}},
+		{Method: "one.vrouter.info", Params: []string{"7"}},
+	}
+	standIn.checkCalls(t, expected)
+	if nicIds := virtualRouterNicIds(nics); !reflect.DeepEqual(nicIds, []int{0, 3}) {
+		t.Errorf("Expected the NICs to have IDs [0 3], got %v", nicIds)
+	}
+	if ip := nics[0].(map[string]interface{})["ip"]; ip != "10.0.0.1" {
+		t.Errorf("Expected the kept NIC to keep its floating IP, got %v", ip)
+	}
+
+	// removing a NIC in the middle leaves the ones after it alone, whatever they carry over from it
+	standIn.Calls = nil
+	nics, err = updateVirtualRouterNics(client, 7, old, []interface{}{nic(0, 1, true, "10.0.0.1"), nic(1, 3, false, "")})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected = []xmlRpcCall{
+		{Method: "one.vrouter.detachnic", Params: []string{"7", "1"}},
+	}
+	standIn.checkCalls(t, expected)
+	if nicIds := virtualRouterNicIds(nics); !reflect.DeepEqual(nicIds, []int{0, 2}) {
+		t.Errorf("Expected the last NIC to keep its ID, got %v", nicIds)
+	}
+
+	// replacing a NIC in the middle attaches the new one without the floating IP of the one it replaces
+	standIn.Calls = nil
+	replaced := []interface{}{nic(0, 1, true, "10.0.0.1"), nic(1, 4, true, ""), nic(2, 3, false, "")}
+	replaced[1].(map[string]interface{})["ip"] = "10.0.2.1"
+	old[1].(map[string]interface{})["ip"] = "10.0.2.1"
+	nics, err = updateVirtualRouterNics(client, 7, old, replaced)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected = []xmlRpcCall{
+		{Method: "one.vrouter.detachnic", Params: []string{"7", "1"}},
+		{Method: "one.vrouter.attachnic", Params: []string{"7", "NIC = [\n  NETWORK_ID = \"4\",\n  FLOATING_IP = \"YES\" ]"}},
+		{Method: "one.vrouter.info", Params: []string{"7"}},
+	}
+	standIn.checkCalls(t, expected)
+	if nicIds := virtualRouterNicIds(nics); !reflect.DeepEqual(nicIds, []int{0, 3, 2}) {
+		t.Errorf("Expected the replaced NIC to get a new ID in place, got %v", nicIds)
+	}
+
+	standIn.Calls = nil
+	if _, err = updateVirtualRouterNics(client, 7, old, []interface{}{nic(0, 2, false, "10.0.4.1")}); err == nil {
+		t.Errorf("Expected an ip without a floating_ip to be invalid")
+	}
+	if len(standIn.Calls) != 0 {
+		t.Errorf("Expected no calls for invalid NICs, got %v", standIn.Calls)
+	}
+}
+
+func TestVirtualRouterNics(t *testing.T) {
+	vr := &VirtualRouter{Template: &VirtualRouterTemplate{NICs: []*VirtualRouterNic{
+		{NicId: 0, NetworkId: 1},
+		{NicId: 2, NetworkId: 3},
+		{NicId: 3, NetworkId: 4},
+	}}}
+	known := []interface{}{
+		map[string]interface{}{"nic_id": 0},
+		map[string]interface{}{"nic_id": 3},
+		map[string]interface{}{"nic_id": 1},
+	}
+
+	nics := make([]interface{}, 0)
+	for _, nic := range virtualRouterNics(vr, known) {
+		nics = append(nics, nic)
+	}
+	if nicIds := virtualRouterNicIds(nics); !reflect.DeepEqual(nicIds, []int{0, 3, 2}) {
+		t.Errorf("Expected the NICs in the known order, then the unknown ones, got %v", nicIds)
+	}
+}
+
+func virtualRouterNicIds(nics []interface{}) []int {
+	nicIds := make([]int, 0, len(nics))
+	for _, nic := range nics {
+		nicIds = append(nicIds, nic.(map[string]interface{})["nic_id"].(int))
+	}
+
+	return nicIds
+}
+
+func testAccCheckVirtualRouterDestroy(s *terraform.State) error {
+	client := testAccProvider.Meta().(*Client)
+
+	for _, rs := range s.RootModule().Resources {
+		method := "one.vrouter.info"
+		switch rs.Type {
+		case "opennebula_vnet":
+			method = "one.vn.info"
+		case "opennebula_template":
+			method = "one.template.info"
+		}
+
+		_, err := client.Call(method, intId(rs.Primary.ID), false)
+		if err == nil {
+			return fmt.Errorf("Expected %s %s to have been destroyed", rs.Type, rs.Primary.ID)
+		}
+	}
+
+	return nil
+}
+
+var testAccVirtualRouterConfigBasic = `
+resource "opennebula_vnet" "test" {
+  name = "test-vrouter-vnet"
+  vn_mad = "dummy"
+  bridge = "br-test"
+  permissions = "642"
+
+  address_range {
+    type = "IP4"
+    ip = "192.168.0.1"
+    size = 20
+  }
+}
+
+resource "opennebula_template" "test" {
+  name = "test-vrouter-template"
+  description = <<EOF
+	CPU = "0.1"
+	MEMORY = "64"
+	VROUTER = "YES"
+  EOF
+  permissions = "642"
+}
+
+resource "opennebula_virtual_router" "test" {
+  name = "test-vrouter"
+  template_id = "${opennebula_template.test.id}"
+  instances = 2
+  keepalived_password = "secret"
+  permissions = "642"
+
+  nic {
+    network_id = "${opennebula_vnet.test.id}"
+    floating_ip = true
+    ip = "192.168.0.10"
+  }
+}
+`
+
+var testAccVirtualRouterConfigUpdate = `
+resource "opennebula_vnet" "test" {
+  name = "test-vrouter-vnet"
+  vn_mad = "dummy"
+  bridge = "br-test"
+  permissions = "642"
+
+  address_range {
+    type = "IP4"
+    ip = "192.168.0.1"
+    size = 20
+  }
+}
+
+resource "opennebula_template" "test" {
+  name = "test-vrouter-template"
+  description = <<EOF
+	CPU = "0.1"
+	MEMORY = "64"
+	VROUTER = "YES"
+  EOF
+  permissions = "642"
+}
+
+resource "opennebula_virtual_router" "test" {
+  name = "test-vrouter-renamed"
+  description = "Edge router"
+  template_id = "${opennebula_template.test.id}"
+  instances = 2
+  keepalived_password = "secret"
+  permissions = "600"
+
+  nic {
+    network_id = "${opennebula_vnet.test.id}"
+    floating_ip = true
+    ip = "192.168.0.10"
+  }
+
+  nic {
+    network_id = "${opennebula_vnet.test.id}"
+  }
+}
+`
@@ -5,22 +5,30 @@ import (
 	"fmt"
 	"github.com/hashicorp/terraform/helper/resource"
 	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
+	"github.com/runtastic/terraform-provider-opennebula/opennebula/template"
 	"log"
 	"strings"
 	"time"
 )
 
 type UserVm struct {
-	Id          string       `xml:"ID"`
-	Name        string       `xml:"NAME"`
-	Uid         int          `xml:"UID"`
-	Gid         int          `xml:"GID"`
-	Uname       string       `xml:"UNAME"`
-	Gname       string       `xml:"GNAME"`
-	Permissions *Permissions `xml:"PERMISSIONS"`
-	State       int          `xml:"STATE"`
-	LcmState    int          `xml:"LCM_STATE"`
-	VmTemplate  *VmTemplate  `xml:"TEMPLATE"`
+	Id             string        `xml:"ID"`
+	Name           string        `xml:"NAME"`
+	Uid            int           `xml:"UID"`
+	Gid            int           `xml:"GID"`
+	Uname          string        `xml:"UNAME"`
+	Gname          string        `xml:"GNAME"`
+	Permissions    *Permissions  `xml:"PERMISSIONS"`
+	Lock           *Lock         `xml:"LOCK"`
+	State          int           `xml:"STATE"`
+	LcmState       int           `xml:"LCM_STATE"`
+	STime          int           `xml:"STIME"`
+	ETime          int           `xml:"ETIME"`
+	DeployId       string        `xml:"DEPLOY_ID"`
+	Monitoring     *VmMonitoring `xml:"MONITORING"`
+	VmTemplate     *VmTemplate   `xml:"TEMPLATE"`
+	HistoryRecords []*VmHistory  `xml:"HISTORY_RECORDS>HISTORY"`
 }
 
 type UserVms struct {
@@ -28,7 +36,53 @@ type UserVms struct {
 }
 
 type VmTemplate struct {
-	Context *Context `xml:"CONTEXT"`
+	TemplateId int         `xml:"TEMPLATE_ID"`
+	CPU        float64     `xml:"CPU"`
+	VCPU       int         `xml:"VCPU"`
+	Memory     int         `xml:"MEMORY"`
+	NICs       []*VmNic    `xml:"NIC"`
+	NICAliases []*VmNic    `xml:"NIC_ALIAS"`
+	Graphics   *VmGraphics `xml:"GRAPHICS"`
+	VmGroup    *VmGroupRef `xml:"VMGROUP"`
+	Context    *Context    `xml:"CONTEXT"`
+}
+
+type VmGroupRef struct {
+	VmGroupId int    `xml:"VMGROUP_ID"`
+	Role      string `xml:"ROLE"`
+}
+
+type VmNic struct {
+	NicId     int    `xml:"NIC_ID"`
+	Parent    string `xml:"PARENT"`
+	Network   string `xml:"NETWORK"`
+	NetworkId int    `xml:"NETWORK_ID"`
+	IP        string `xml:"IP"`
+	IP6       string `xml:"IP6"`
+	IP6Global string `xml:"IP6_GLOBAL"`
+	IP6ULA    string `xml:"IP6_ULA"`
+	IP6Link   string `xml:"IP6_LINK"`
+	MAC       string `xml:"MAC"`
+}
+
+type VmGraphics struct {
+	Type   string `xml:"TYPE"`
+	Listen string `xml:"LISTEN"`
+	Port   int    `xml:"PORT"`
+}
+
+type VmMonitoring struct {
+	CPU    float64 `xml:"CPU"`
+	Memory int     `xml:"MEMORY"`
+	NetTX  int     `xml:"NETTX"`
+	NetRX  int     `xml:"NETRX"`
+}
+
+type VmHistory struct {
+	Seq      int    `xml:"SEQ"`
+	Hostname string `xml:"HOSTNAME"`
+	HostId   int    `xml:"HID"`
+	STime    int    `xml:"STIME"`
 }
 
 type Context struct {
@@ -122,6 +176,174 @@ func resourceVm() *schema.Resource {
 				Computed:    true,
 				Description: "Current LCM state of the VM",
 			},
+			"state_name": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the current state of the VM, e.g. ACTIVE",
+			},
+			"lcm_state_name": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the current LCM state of the VM, e.g. RUNNING",
+			},
+			"vmgroup": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				MaxItems:    1,
+				Description: "VM group and role the VM belongs to. Read from the template of the VM if not set",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"vmgroup_id": {
+							Type:        schema.TypeInt,
+							Required:    true,
+							ForceNew:    true,
+							Description: "ID of the VM group",
+						},
+						"role": {
+							Type:        schema.TypeString,
+							Required:    true,
+							ForceNew:    true,
+							Description: "Name of the role of the VM within the VM group",
+						},
+					},
+				},
+			},
+			"ips": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "All IPv4 and IPv6 addresses of the VM's NICs and NIC aliases",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
+			"macs": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "MAC addresses of the VM's NICs and NIC aliases",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
+			"nic": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "NICs and NIC aliases attached to the VM",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"nic_id": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"parent": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Name of the NIC this alias belongs to, empty for regular NICs",
+						},
+						"network": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"network_id": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"ip": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"ip6": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"ip6_global": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"ip6_ula": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"ip6_link": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"mac": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+					},
+				},
+			},
+			"host": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the host the VM is currently deployed on",
+			},
+			"host_id": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the host the VM is currently deployed on",
+			},
+			"deploy_id": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "ID of the VM in the hypervisor",
+			},
+			"start_time": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Start time of the VM (epoch)",
+			},
+			"cpu": {
+				Type:        schema.TypeFloat,
+				Computed:    true,
+				Description: "CPU allocated to the VM",
+			},
+			"vcpu": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Virtual CPUs allocated to the VM",
+			},
+			"memory": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Memory allocated to the VM (in MB)",
+			},
+			"vnc_port": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Port of the VM's VNC server",
+			},
+			"monitoring_cpu": {
+				Type:        schema.TypeFloat,
+				Computed:    true,
+				Description: "Latest CPU usage of the VM (percentage of one CPU)",
+			},
+			"monitoring_memory": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Latest memory usage of the VM (in KB)",
+			},
+			"monitoring_nettx": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Bytes sent by the VM up to the latest monitoring",
+			},
+			"monitoring_netrx": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Bytes received by the VM up to the latest monitoring",
+			},
+			"lock": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "Lock level of the VM: USE, MANAGE, ADMIN or ALL. Locked VMs can't be destroyed",
+				ValidateFunc: validation.StringInSlice([]string{"USE", "MANAGE", "ADMIN", "ALL"}, false),
+			},
+			"adopt_by_name": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "If the VM can't be found by ID, adopt the only running VM of the user with the same name",
+			},
 		},
 	}
 }
@@ -134,7 +356,7 @@ func resourceVmCreate(d *schema.ResourceData, meta interface{}) error {
 		d.Get("template_id"),
 		d.Get("name"),
 		false,
-		"",
+		vmExtraTemplate(d).String(),
 		false,
 	)
 	if err != nil {
@@ -153,15 +375,29 @@ func resourceVmCreate(d *schema.ResourceData, meta interface{}) error {
 		return err
 	}
 
+	if err = changeLock(intId(d.Id()), "", d.Get("lock").(string), client, "one.vm"); err != nil {
+		return err
+	}
+
 	return resourceVmRead(d, meta)
 }
 
+// vmExtraTemplate renders the attributes that are merged into the VM template on instantiation
+func vmExtraTemplate(d *schema.ResourceData) *template.Template {
+	tmpl := template.New()
+
+	if groups := d.Get("vmgroup").([]interface{}); len(groups) > 0 {
+		group := groups[0].(map[string]interface{})
+		tmpl.AddVector("VMGROUP").Add("VMGROUP_ID", group["vmgroup_id"].(int)).Add("ROLE", group["role"].(string))
+	}
+
+	return tmpl
+}
+
 func resourceVmRead(d *schema.ResourceData, meta interface{}) error {
 	var vm *UserVm
-	var vms *UserVms
 
 	client := meta.(*Client)
-	found := false
 	name := d.Get("name").(string)
 	if name == "" {
 		name = d.Get("instance").(string)
@@ -169,41 +405,31 @@ func resourceVmRead(d *schema.ResourceData, meta interface{}) error {
 
 	// Try to find the vm by ID, if specified
 	if d.Id() != "" {
-		resp, err := client.Call("one.vm.info", intId(d.Id()))
-		if err == nil {
-			found = true
-			if err = xml.Unmarshal([]byte(resp), &vm); err != nil {
-				return err
-			}
-		} else {
-			log.Printf("Could not find VM by ID %s", d.Id())
-		}
-	}
-
-	// Otherwise, try to find the vm by (user, name) as the de facto compound primary key
-	if d.Id() == "" || !found {
-		resp, err := client.Call("one.vmpool.info", -3, -1, -1)
+		found, err := findVmById(client, d.Id())
 		if err != nil {
 			return err
 		}
+		vm = found
+	}
 
-		if err = xml.Unmarshal([]byte(resp), &vms); err != nil {
-			return err
+	// Otherwise, only adopt a vm by (user, name) as the de facto compound primary key if asked to
+	if vm == nil {
+		if !d.Get("adopt_by_name").(bool) {
+			d.SetId("")
+			return nil
 		}
 
-		for _, v := range vms.UserVm {
-			if v.Name == name {
-				vm = v
-				found = true
-				break
-			}
+		found, err := findVmByName(client, name)
+		if err != nil {
+			return err
 		}
 
-		if !found || vm == nil {
+		if found == nil {
 			d.SetId("")
 			log.Printf("Could not find vm with name %s for user %s", name, client.Username)
 			return nil
 		}
+		vm = found
 	}
 
 	d.SetId(vm.Id)
@@ -214,33 +440,204 @@ func resourceVmRead(d *schema.ResourceData, meta interface{}) error {
 	d.Set("gname", vm.Gname)
 	d.Set("state", vm.State)
 	d.Set("lcmstate", vm.LcmState)
-	d.Set("ip", vm.VmTemplate.Context.IP)
+	d.Set("state_name", vmStateName(vm.State))
+	d.Set("lcm_state_name", vmLcmStateName(vm.LcmState))
+	d.Set("deploy_id", vm.DeployId)
+	d.Set("start_time", vm.STime)
 	d.Set("permissions", permissionString(vm.Permissions))
+	d.Set("lock", lockLevelName(vm.Lock))
+
+	if err := setVmTemplateAttributes(d, vm.VmTemplate); err != nil {
+		return err
+	}
+
+	host, hostId := vmHost(vm)
+	d.Set("host", host)
+	d.Set("host_id", hostId)
+
+	if vm.Monitoring != nil {
+		d.Set("monitoring_cpu", vm.Monitoring.CPU)
+		d.Set("monitoring_memory", vm.Monitoring.Memory)
+		d.Set("monitoring_nettx", vm.Monitoring.NetTX)
+		d.Set("monitoring_netrx", vm.Monitoring.NetRX)
+	}
 
 	return nil
 }
 
+// setVmTemplateAttributes sets the attributes that OpenNebula keeps in the VM's TEMPLATE,
+// i.e. the allocated capacity, the NICs and the graphics
+func setVmTemplateAttributes(d *schema.ResourceData, tmpl *VmTemplate) error {
+	if tmpl == nil {
+		return nil
+	}
+
+	d.Set("cpu", tmpl.CPU)
+	d.Set("vcpu", tmpl.VCPU)
+	d.Set("memory", tmpl.Memory)
+
+	vmgroups := make([]map[string]interface{}, 0)
+	if tmpl.VmGroup != nil {
+		vmgroups = append(vmgroups, map[string]interface{}{
+			"vmgroup_id": tmpl.VmGroup.VmGroupId,
+			"role":       tmpl.VmGroup.Role,
+		})
+	}
+	if err := d.Set("vmgroup", vmgroups); err != nil {
+		return err
+	}
+
+	vncPort := 0
+	if tmpl.Graphics != nil && strings.ToUpper(tmpl.Graphics.Type) == "VNC" {
+		vncPort = tmpl.Graphics.Port
+	}
+	d.Set("vnc_port", vncPort)
+
+	nics, ips, macs := vmNics(tmpl)
+	if err := d.Set("nic", nics); err != nil {
+		return err
+	}
+	if err := d.Set("ips", ips); err != nil {
+		return err
+	}
+	if err := d.Set("macs", macs); err != nil {
+		return err
+	}
+
+	d.Set("ip", vmIP(tmpl))
+
+	return nil
+}
+
+// vmNics returns the NICs and NIC aliases of a VM as set in the nic attribute,
+// along with all their IPs (IPv4 and IPv6) and MACs
+func vmNics(tmpl *VmTemplate) ([]map[string]interface{}, []string, []string) {
+	nics := make([]map[string]interface{}, 0, len(tmpl.NICs)+len(tmpl.NICAliases))
+	ips := make([]string, 0)
+	macs := make([]string, 0)
+	for _, nic := range append(append([]*VmNic{}, tmpl.NICs...), tmpl.NICAliases...) {
+		nics = append(nics, map[string]interface{}{
+			"nic_id":     nic.NicId,
+			"parent":     nic.Parent,
+			"network":    nic.Network,
+			"network_id": nic.NetworkId,
+			"ip":         nic.IP,
+			"ip6":        nic.IP6,
+			"ip6_global": nic.IP6Global,
+			"ip6_ula":    nic.IP6ULA,
+			"ip6_link":   nic.IP6Link,
+			"mac":        nic.MAC,
+		})
+
+		for _, ip := range []string{nic.IP, nic.IP6, nic.IP6Global, nic.IP6ULA, nic.IP6Link} {
+			if ip != "" {
+				ips = append(ips, ip)
+			}
+		}
+		if nic.MAC != "" {
+			macs = append(macs, nic.MAC)
+		}
+	}
+
+	return nics, ips, macs
+}
+
+// vmIP returns the IP the VM configures itself with from its context, falling back to the first NIC
+func vmIP(tmpl *VmTemplate) string {
+	if tmpl.Context != nil && tmpl.Context.IP != "" {
+		return tmpl.Context.IP
+	} else if len(tmpl.NICs) > 0 {
+		return tmpl.NICs[0].IP
+	}
+
+	return ""
+}
+
+// vmHost returns the name and ID of the host the VM is currently deployed on, as told
+// by its last history record, or no host and ID -1 if it has never been deployed
+func vmHost(vm *UserVm) (string, int) {
+	if n := len(vm.HistoryRecords); n > 0 {
+		return vm.HistoryRecords[n-1].Hostname, vm.HistoryRecords[n-1].HostId
+	}
+
+	return "", -1
+}
+
+// findVmById returns the VM with the given ID, or nil if it doesn't exist (anymore).
+// Any other error is returned, so that a VM isn't taken for gone because oned couldn't be asked.
+func findVmById(client *Client, id string) (*UserVm, error) {
+	var vm *UserVm
+
+	resp, err := client.Call("one.vm.info", intId(id))
+	if isNotFound(err) {
+		log.Printf("Could not find VM by ID %s", id)
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err = xml.Unmarshal([]byte(resp), &vm); err != nil {
+		return nil, err
+	}
+
+	return vm, nil
+}
+
+// findVmByName looks for a single VM that is owned by the user and is not DONE.
+// It returns nil if there is no such VM and fails if the name is ambiguous.
+func findVmByName(client *Client, name string) (*UserVm, error) {
+	var vms *UserVms
+	var vm *UserVm
+
+	// -3: resources belonging to the user, -1: any state except DONE
+	resp, err := client.Call("one.vmpool.info", -3, -1, -1, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = xml.Unmarshal([]byte(resp), &vms); err != nil {
+		return nil, err
+	}
+
+	for _, v := range vms.UserVm {
+		if v.Name != name || v.Uname != client.Username || v.State == vmStateDone {
+			continue
+		}
+		if vm != nil {
+			return nil, fmt.Errorf("Found more than one VM with name %s for user %s (IDs %s and %s)", name, client.Username, vm.Id, v.Id)
+		}
+		vm = v
+	}
+
+	return vm, nil
+}
+
 func resourceVmExists(d *schema.ResourceData, meta interface{}) (bool, error) {
 	err := resourceVmRead(d, meta)
-	// a terminated VM is in state 6 (DONE)
-	if err != nil || d.Id() == "" || d.Get("state").(int) == 6 {
+	// a terminated VM stays around in state DONE
+	if err != nil || d.Id() == "" || d.Get("state").(int) == vmStateDone {
 		return false, err
 	}
 
 	return true, nil
 }
 
-func resourceVmUpdate(d *schema.ResourceData, meta interface{}) error {
+func resourceVmUpdate(d *schema.ResourceData, meta interface{}) (err error) {
 	client := meta.(*Client)
 
+	if err = unlockForUpdate(d, client, "one.vm"); err != nil {
+		return err
+	}
+	defer relockAfterUpdate(d, client, "one.vm", &err)
+
 	if d.HasChange("permissions") {
 		resp, err := changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.vm.chmod")
 		if err != nil {
 			return err
 		}
 		log.Printf("[INFO] Successfully updated VM %s\n", resp)
-	} else {
-		log.Printf("[INFO] Sorry, only 'permissions' updates are supported at the moment.")
+	} else if !d.HasChange("lock") {
+		log.Printf("[INFO] Sorry, only 'permissions' and 'lock' updates are supported at the moment.")
 	}
 
 	return nil
@@ -252,6 +649,10 @@ func resourceVmDelete(d *schema.ResourceData, meta interface{}) error {
 		return err
 	}
 
+	if err = checkUnlocked("VM", d.Id(), d.Get("lock").(string)); err != nil {
+		return err
+	}
+
 	client := meta.(*Client)
 	resp, err := client.Call("one.vm.action", "terminate-hard", intId(d.Id()))
 	if err != nil {
@@ -269,30 +670,34 @@ func resourceVmDelete(d *schema.ResourceData, meta interface{}) error {
 }
 
 func waitForVmState(d *schema.ResourceData, meta interface{}, state string) (interface{}, error) {
+	return waitForVm(meta.(*Client), d.Id(), state)
+}
+
+// waitForVm waits for the VM with the given ID to be running or done, as in state
+func waitForVm(client *Client, id string, state string) (interface{}, error) {
 	var vm *UserVm
-	client := meta.(*Client)
 
-	log.Printf("Waiting for VM (%s) to be in state Done", d.Id())
+	log.Printf("Waiting for VM (%s) to be in state %s", id, state)
 
 	stateConf := &resource.StateChangeConf{
 		Pending: []string{"anythingelse"},
 		Target:  []string{state},
 		Refresh: func() (interface{}, string, error) {
 			log.Println("Refreshing VM state...")
-			if d.Id() != "" {
-				resp, err := client.Call("one.vm.info", intId(d.Id()))
+			if id != "" {
+				resp, err := client.Call("one.vm.info", intId(id))
 				if err == nil {
 					if err = xml.Unmarshal([]byte(resp), &vm); err != nil {
 						return nil, "", fmt.Errorf("Couldn't fetch VM state: %s", err)
 					}
 				} else {
-					return nil, "", fmt.Errorf("Could not find VM by ID %s", d.Id())
+					return nil, "", fmt.Errorf("Could not find VM by ID %s", id)
 				}
 			}
-			log.Printf("VM is currently in state %v and in LCM state %v", vm.State, vm.LcmState)
-			if vm.State == 3 && vm.LcmState == 3 {
+			log.Printf("VM is currently in state %s and in LCM state %s", vmStateName(vm.State), vmLcmStateName(vm.LcmState))
+			if vm.State == vmStateActive && vm.LcmState == vmLcmStateRunning {
 				return vm, "running", nil
-			} else if vm.State == 6 {
+			} else if vm.State == vmStateDone {
 				return vm, "done", nil
 			} else {
 				return nil, "anythingelse", nil
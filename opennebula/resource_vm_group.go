@@ -0,0 +1,361 @@
+package opennebula
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
+	"github.com/runtastic/terraform-provider-opennebula/opennebula/template"
+	"log"
+	"strconv"
+	"strings"
+)
+
+type VmGroup struct {
+	Name        string          `xml:"NAME"`
+	Id          int             `xml:"ID"`
+	Uid         int             `xml:"UID"`
+	Gid         int             `xml:"GID"`
+	Uname       string          `xml:"UNAME"`
+	Gname       string          `xml:"GNAME"`
+	Permissions *Permissions    `xml:"PERMISSIONS"`
+	Roles       []*VmGroupRole  `xml:"ROLES>ROLE"`
+	Template    VmGroupTemplate `xml:"TEMPLATE"`
+}
+
+type VmGroupRole struct {
+	Id              int    `xml:"ID"`
+	Name            string `xml:"NAME"`
+	Policy          string `xml:"POLICY"`
+	HostAffined     string `xml:"HOST_AFFINED"`
+	HostAntiAffined string `xml:"HOST_ANTI_AFFINED"`
+}
+
+type VmGroupTemplate struct {
+	Affined     []string `xml:"AFFINED"`
+	AntiAffined []string `xml:"ANTI_AFFINED"`
+}
+
+func resourceVmGroup() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceVmGroupCreate,
+		Read:   resourceVmGroupRead,
+		Exists: resourceVmGroupExists,
+		Update: resourceVmGroupUpdate,
+		Delete: resourceVmGroupDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Name of the VM group",
+			},
+			"permissions": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Permissions for the VM group (in Unix format, owner-group-other, use-manage-admin)",
+				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+					value := v.(string)
+
+					if len(value) != 3 {
+						errors = append(errors, fmt.Errorf("%q has specify 3 permission sets: owner-group-other", k))
+					}
+
+					all := true
+					for _, c := range strings.Split(value, "") {
+						if c < "0" || c > "7" {
+							all = false
+						}
+					}
+					if !all {
+						errors = append(errors, fmt.Errorf("Each character in %q should specify a Unix-like permission set with a number from 0 to 7", k))
+					}
+
+					return
+				},
+			},
+
+			"uid": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the user that will own the VM group",
+			},
+			"gid": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the group that will own the VM group",
+			},
+			"uname": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the user that will own the VM group",
+			},
+			"gname": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the group that will own the VM group",
+			},
+			"role": {
+				Type:        schema.TypeList,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Roles of the VM group. VMs join the group with one of these roles",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"id": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "ID of the role within the VM group",
+						},
+						"name": {
+							Type:        schema.TypeString,
+							Required:    true,
+							ForceNew:    true,
+							Description: "Name of the role",
+						},
+						"policy": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ForceNew:     true,
+							Default:      "NONE",
+							Description:  "Placement policy among the VMs of the role: NONE, AFFINED or ANTI_AFFINED",
+							ValidateFunc: validation.StringInSlice([]string{"NONE", "AFFINED", "ANTI_AFFINED"}, false),
+						},
+						"host_affined": {
+							Type:        schema.TypeList,
+							Optional:    true,
+							ForceNew:    true,
+							Description: "IDs of the hosts the VMs of the role have to run on",
+							Elem:        &schema.Schema{Type: schema.TypeInt},
+						},
+						"host_anti_affined": {
+							Type:        schema.TypeList,
+							Optional:    true,
+							ForceNew:    true,
+							Description: "IDs of the hosts the VMs of the role must not run on",
+							Elem:        &schema.Schema{Type: schema.TypeInt},
+						},
+					},
+				},
+			},
+			"rule": {
+				Type:        schema.TypeSet,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "Affinity rules between the roles of the VM group",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"type": {
+							Type:         schema.TypeString,
+							Required:     true,
+							ForceNew:     true,
+							Description:  "Whether the VMs of the roles run on the same (AFFINED) or different (ANTI_AFFINED) hosts",
+							ValidateFunc: validation.StringInSlice([]string{"AFFINED", "ANTI_AFFINED"}, false),
+						},
+						"roles": {
+							Type:        schema.TypeList,
+							Required:    true,
+							ForceNew:    true,
+							MinItems:    2,
+							Description: "Names of the roles the rule applies to",
+							Elem:        &schema.Schema{Type: schema.TypeString},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func resourceVmGroupCreate(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*Client)
+
+	resp, err := client.Call(
+		"one.vmgroup.allocate",
+		vmGroupTemplate(d).String(),
+	)
+	if err != nil {
+		return err
+	}
+
+	d.SetId(resp)
+
+	if _, err = changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.vmgroup.chmod"); err != nil {
+		return err
+	}
+
+	return resourceVmGroupRead(d, meta)
+}
+
+// vmGroupTemplate renders the roles and rules of the VM group
+func vmGroupTemplate(d *schema.ResourceData) *template.Template {
+	tmpl := template.New().Add("NAME", d.Get("name").(string))
+
+	for _, r := range d.Get("role").([]interface{}) {
+		role := r.(map[string]interface{})
+		vector := tmpl.AddVector("ROLE").Add("NAME", role["name"].(string)).Add("POLICY", role["policy"].(string))
+		if hosts := role["host_affined"].([]interface{}); len(hosts) > 0 {
+			vector.Add("HOST_AFFINED", joinInts(hosts))
+		}
+		if hosts := role["host_anti_affined"].([]interface{}); len(hosts) > 0 {
+			vector.Add("HOST_ANTI_AFFINED", joinInts(hosts))
+		}
+	}
+
+	for _, r := range d.Get("rule").(*schema.Set).List() {
+		rule := r.(map[string]interface{})
+		roles := make([]string, 0)
+		for _, name := range rule["roles"].([]interface{}) {
+			roles = append(roles, name.(string))
+		}
+		tmpl.Add(rule["type"].(string), strings.Join(roles, ","))
+	}
+
+	return tmpl
+}
+
+func resourceVmGroupRead(d *schema.ResourceData, meta interface{}) error {
+	var vmg *VmGroup
+
+	client := meta.(*Client)
+
+	resp, err := client.Call("one.vmgroup.info", intId(d.Id()), false)
+	if err != nil {
+		if isNotFound(err) {
+			log.Printf("Could not find VM group by ID %s", d.Id())
+			d.SetId("")
+			return nil
+		}
+		return err
+	}
+
+	if err = xml.Unmarshal([]byte(resp), &vmg); err != nil {
+		return err
+	}
+
+	roles := make([]map[string]interface{}, 0, len(vmg.Roles))
+	for _, role := range vmg.Roles {
+		policy := role.Policy
+		if policy == "" {
+			policy = "NONE"
+		}
+		roles = append(roles, map[string]interface{}{
+			"id":                role.Id,
+			"name":              role.Name,
+			"policy":            policy,
+			"host_affined":      splitInts(role.HostAffined),
+			"host_anti_affined": splitInts(role.HostAntiAffined),
+		})
+	}
+
+	rules := make([]interface{}, 0)
+	for _, value := range vmg.Template.Affined {
+		rules = append(rules, vmGroupRule("AFFINED", value))
+	}
+	for _, value := range vmg.Template.AntiAffined {
+		rules = append(rules, vmGroupRule("ANTI_AFFINED", value))
+	}
+
+	d.SetId(strconv.Itoa(vmg.Id))
+	d.Set("name", vmg.Name)
+	d.Set("uid", vmg.Uid)
+	d.Set("gid", vmg.Gid)
+	d.Set("uname", vmg.Uname)
+	d.Set("gname", vmg.Gname)
+	d.Set("permissions", permissionString(vmg.Permissions))
+	if err = d.Set("role", roles); err != nil {
+		return err
+	}
+	if err = d.Set("rule", rules); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// vmGroupRule parses a rule such as AFFINED = "web, db" from the VM group template
+func vmGroupRule(ruleType, value string) map[string]interface{} {
+	names := make([]interface{}, 0)
+	for _, name := range strings.Split(value, ",") {
+		names = append(names, strings.TrimSpace(name))
+	}
+
+	return map[string]interface{}{
+		"type":  ruleType,
+		"roles": names,
+	}
+}
+
+func resourceVmGroupExists(d *schema.ResourceData, meta interface{}) (bool, error) {
+	err := resourceVmGroupRead(d, meta)
+	if err != nil || d.Id() == "" {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func resourceVmGroupUpdate(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*Client)
+
+	if d.HasChange("name") {
+		resp, err := client.Call(
+			"one.vmgroup.rename",
+			intId(d.Id()),
+			d.Get("name").(string),
+		)
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated name for VM group %s\n", resp)
+	}
+
+	if d.HasChange("permissions") {
+		resp, err := changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.vmgroup.chmod")
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated VM group %s\n", resp)
+	}
+
+	return nil
+}
+
+func resourceVmGroupDelete(d *schema.ResourceData, meta interface{}) error {
+	err := resourceVmGroupRead(d, meta)
+	if err != nil || d.Id() == "" {
+		return err
+	}
+
+	client := meta.(*Client)
+	resp, err := client.Call("one.vmgroup.delete", intId(d.Id()))
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Successfully deleted VM group %s\n", resp)
+	return nil
+}
+
+func joinInts(values []interface{}) string {
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		strs = append(strs, strconv.Itoa(v.(int)))
+	}
+
+	return strings.Join(strs, ",")
+}
+
+func splitInts(value string) []int {
+	ints := make([]int, 0)
+	for _, v := range strings.Split(value, ",") {
+		if i, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			ints = append(ints, i)
+		}
+	}
+
+	return ints
+}
@@ -0,0 +1,139 @@
+package opennebula
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/terraform"
+	"reflect"
+	"testing"
+)
+
+func TestAccVmGroup(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVmGroupDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccVmGroupConfigBasic,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_virtual_machine_group.test", "name", "test-vmgroup"),
+					resource.TestCheckResourceAttr("opennebula_virtual_machine_group.test", "permissions", "642"),
+					resource.TestCheckResourceAttr("opennebula_virtual_machine_group.test", "role.#", "2"),
+					resource.TestCheckResourceAttr("opennebula_virtual_machine_group.test", "role.0.name", "web"),
+					resource.TestCheckResourceAttr("opennebula_virtual_machine_group.test", "role.0.policy", "ANTI_AFFINED"),
+					resource.TestCheckResourceAttr("opennebula_virtual_machine_group.test", "role.1.name", "db"),
+					resource.TestCheckResourceAttr("opennebula_virtual_machine_group.test", "rule.#", "1"),
+					resource.TestCheckResourceAttrSet("opennebula_virtual_machine_group.test", "uid"),
+					resource.TestCheckResourceAttrSet("opennebula_virtual_machine_group.test", "gid"),
+					resource.TestCheckResourceAttrSet("opennebula_virtual_machine_group.test", "uname"),
+					resource.TestCheckResourceAttrSet("opennebula_virtual_machine_group.test", "gname"),
+					testAccCheckVmGroupPermissions(&Permissions{
+						Owner_U: 1,
+						Owner_M: 1,
+						Group_U: 1,
+						Other_M: 1,
+					}),
+				),
+			},
+			{
+				Config: testAccVmGroupConfigUpdate,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_virtual_machine_group.test", "name", "test-vmgroup-renamed"),
+					resource.TestCheckResourceAttr("opennebula_virtual_machine_group.test", "permissions", "600"),
+					testAccCheckVmGroupPermissions(&Permissions{
+						Owner_U: 1,
+						Owner_M: 1,
+					}),
+				),
+			},
+		},
+	})
+}
+
+func testAccCheckVmGroupDestroy(s *terraform.State) error {
+	client := testAccProvider.Meta().(*Client)
+
+	for _, rs := range s.RootModule().Resources {
+		_, err := client.Call("one.vmgroup.info", intId(rs.Primary.ID), false)
+		if err == nil {
+			return fmt.Errorf("Expected VM group %s to have been destroyed", rs.Primary.ID)
+		}
+	}
+
+	return nil
+}
+
+func testAccCheckVmGroupPermissions(expected *Permissions) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		client := testAccProvider.Meta().(*Client)
+
+		for _, rs := range s.RootModule().Resources {
+			resp, err := client.Call("one.vmgroup.info", intId(rs.Primary.ID), false)
+			if err != nil {
+				return fmt.Errorf("Expected VM group %s to exist when checking permissions", rs.Primary.ID)
+			}
+
+			var vmg VmGroup
+			if err = xml.Unmarshal([]byte(resp), &vmg); err != nil {
+				return err
+			}
+
+			if !reflect.DeepEqual(vmg.Permissions, expected) {
+				return fmt.Errorf(
+					"Permissions for VM group %s were expected to be %s. Instead, they were %s",
+					rs.Primary.ID,
+					permissionString(expected),
+					permissionString(vmg.Permissions),
+				)
+			}
+		}
+
+		return nil
+	}
+}
+
+var testAccVmGroupConfigBasic = `
+resource "opennebula_virtual_machine_group" "test" {
+  name = "test-vmgroup"
+  permissions = "642"
+
+  role {
+    name = "web"
+    policy = "ANTI_AFFINED"
+  }
+
+  role {
+    name = "db"
+    host_affined = [0]
+  }
+
+  rule {
+    type = "ANTI_AFFINED"
+    roles = ["web", "db"]
+  }
+}
+`
+
+var testAccVmGroupConfigUpdate = `
+resource "opennebula_virtual_machine_group" "test" {
+  name = "test-vmgroup-renamed"
+  permissions = "600"
+
+  role {
+    name = "web"
+    policy = "ANTI_AFFINED"
+  }
+
+  role {
+    name = "db"
+    host_affined = [0]
+  }
+
+  rule {
+    type = "ANTI_AFFINED"
+    roles = ["web", "db"]
+  }
+}
+`
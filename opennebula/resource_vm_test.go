@@ -0,0 +1,179 @@
+package opennebula
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindVmById(t *testing.T) {
+	client, standIn := newTestClient(t, map[string]func([]string) (interface{}, error){
+		"one.vm.info": func(params []string) (interface{}, error) {
+			switch params[0] {
+			case "1":
+				return "<VM><ID>1</ID><NAME>web</NAME></VM>", nil
+			case "2":
+				return nil, &Error{Code: ErrNoExists, Message: "[one.vm.info] Error getting virtual machine [2]."}
+			default:
+				return nil, &Error{Code: ErrAuthorization, Message: "[one.vm.info] User [3] : Not authorized to perform USE VM [3]."}
+			}
+		},
+	})
+	defer standIn.Close()
+
+	vm, err := findVmById(client, "1")
+	if err != nil || vm == nil || vm.Name != "web" {
+		t.Errorf("Expected to find VM 1, got %v (err: %v)", vm, err)
+	}
+
+	// only a VM that doesn't exist is gone...
+	if vm, err = findVmById(client, "2"); err != nil || vm != nil {
+		t.Errorf("Expected VM 2 to be gone, got %v (err: %v)", vm, err)
+	}
+
+	// ...any other error must not be mistaken for it
+	if vm, err = findVmById(client, "3"); err == nil || !strings.Contains(err.Error(), "Not authorized") {
+		t.Errorf("Expected the error of VM 3 to be returned, got %v (err: %v)", vm, err)
+	}
+}
+
+func TestFindVmByName(t *testing.T) {
+	client, standIn := newTestClient(t, map[string]func([]string) (interface{}, error){
+		"one.vmpool.info": func(params []string) (interface{}, error) {
+			return `<VM_POOL>
+<VM><ID>1</ID><NAME>web</NAME><UNAME>oneadmin</UNAME><STATE>6</STATE></VM>
+<VM><ID>2</ID><NAME>web</NAME><UNAME>serveradmin</UNAME><STATE>3</STATE></VM>
+<VM><ID>3</ID><NAME>web</NAME><UNAME>oneadmin</UNAME><STATE>3</STATE></VM>
+<VM><ID>4</ID><NAME>db</NAME><UNAME>oneadmin</UNAME><STATE>3</STATE></VM>
+<VM><ID>5</ID><NAME>db</NAME><UNAME>oneadmin</UNAME><STATE>8</STATE></VM>
+</VM_POOL>`, nil
+		},
+	})
+	defer standIn.Close()
+
+	// VMs that are DONE or belong to other users are never adopted
+	vm, err := findVmByName(client, "web")
+	if err != nil || vm == nil || vm.Id != "3" {
+		t.Errorf("Expected to adopt VM 3, got %v (err: %v)", vm, err)
+	}
+
+	if vm, err = findVmByName(client, "db"); err == nil {
+		t.Errorf("Expected the name db to be ambiguous, got %v", vm)
+	}
+
+	if vm, err = findVmByName(client, "mail"); err != nil || vm != nil {
+		t.Errorf("Expected no VM with name mail, got %v (err: %v)", vm, err)
+	}
+
+	expected := xmlRpcCall{Method: "one.vmpool.info", Params: []string{"-3", "-1", "-1", "-1"}}
+	for _, call := range standIn.Calls {
+		if !reflect.DeepEqual(call, expected) {
+			t.Errorf("Expected only the user's VMs to be listed with %v, got %v", expected, call)
+		}
+	}
+}
+
+func TestFindResourcesByName(t *testing.T) {
+	client, standIn := newTestClient(t, map[string]func([]string) (interface{}, error){
+		"one.imagepool.info": func(params []string) (interface{}, error) {
+			return `<IMAGE_POOL>
+<IMAGE><ID>1</ID><NAME>base</NAME><UNAME>oneadmin</UNAME></IMAGE>
+<IMAGE><ID>2</ID><NAME>base</NAME><UNAME>oneadmin</UNAME></IMAGE>
+</IMAGE_POOL>`, nil
+		},
+		"one.vnpool.info": func(params []string) (interface{}, error) {
+			return `<VNET_POOL>
+<VNET><ID>1</ID><NAME>private</NAME><UNAME>oneadmin</UNAME></VNET>
+<VNET><ID>2</ID><NAME>private</NAME><UNAME>oneadmin</UNAME></VNET>
+</VNET_POOL>`, nil
+		},
+		"one.templatepool.info": func(params []string) (interface{}, error) {
+			return `<VMTEMPLATE_POOL>
+<VMTEMPLATE><ID>1</ID><NAME>small</NAME><UNAME>oneadmin</UNAME></VMTEMPLATE>
+<VMTEMPLATE><ID>2</ID><NAME>small</NAME><UNAME>oneadmin</UNAME></VMTEMPLATE>
+</VMTEMPLATE_POOL>`, nil
+		},
+	})
+	defer standIn.Close()
+
+	// adopting by name fails rather than picking one of several matches
+	for kind, find := range map[string]func() error{
+		"Image":    func() error { _, err := findImageByName(client, "base"); return err },
+		"vnet":     func() error { _, err := findVnetByName(client, "private"); return err },
+		"template": func() error { _, err := findTemplateByName(client, "small"); return err },
+	} {
+		if err := find(); err == nil || !strings.Contains(err.Error(), "more than one") {
+			t.Errorf("Expected the %s name to be ambiguous, got %v", kind, err)
+		}
+	}
+}
+
+func TestVmReadBack(t *testing.T) {
+	client, standIn := newTestClient(t, map[string]func([]string) (interface{}, error){
+		"one.vm.info": func(params []string) (interface{}, error) {
+			return `<VM><ID>7</ID><NAME>web</NAME><STATE>3</STATE><LCM_STATE>3</LCM_STATE><STIME>1500000000</STIME><DEPLOY_ID>one-7</DEPLOY_ID>
+<MONITORING><CPU><![CDATA[12.5]]></CPU><MEMORY><![CDATA[524288]]></MEMORY><NETRX><![CDATA[2048]]></NETRX><NETTX><![CDATA[1024]]></NETTX></MONITORING>
+<TEMPLATE>
+<CPU><![CDATA[0.5]]></CPU><VCPU><![CDATA[2]]></VCPU><MEMORY><![CDATA[1024]]></MEMORY>
+<CONTEXT><ETH0_IP><![CDATA[10.0.0.5]]></ETH0_IP></CONTEXT>
+<GRAPHICS><TYPE><![CDATA[vnc]]></TYPE><PORT><![CDATA[5907]]></PORT></GRAPHICS>
+<NIC><NIC_ID><![CDATA[0]]></NIC_ID><NETWORK><![CDATA[private]]></NETWORK><NETWORK_ID><![CDATA[2]]></NETWORK_ID><IP><![CDATA[10.0.0.5]]></IP><MAC><![CDATA[02:00:0a:00:00:05]]></MAC></NIC>
+<NIC><NIC_ID><![CDATA[1]]></NIC_ID><NETWORK><![CDATA[public]]></NETWORK><NETWORK_ID><![CDATA[3]]></NETWORK_ID><IP6_GLOBAL><![CDATA[2001:db8::5]]></IP6_GLOBAL><IP6_LINK><![CDATA[fe80::5]]></IP6_LINK><MAC><![CDATA[02:00:0a:00:01:05]]></MAC></NIC>
+<NIC_ALIAS><NIC_ID><![CDATA[2]]></NIC_ID><PARENT><![CDATA[NIC0]]></PARENT><NETWORK><![CDATA[private]]></NETWORK><NETWORK_ID><![CDATA[2]]></NETWORK_ID><IP><![CDATA[10.0.0.6]]></IP><MAC><![CDATA[02:00:0a:00:00:06]]></MAC></NIC_ALIAS>
+</TEMPLATE>
+<HISTORY_RECORDS>
+<HISTORY><SEQ>0</SEQ><HOSTNAME>node1</HOSTNAME><HID>1</HID></HISTORY>
+<HISTORY><SEQ>1</SEQ><HOSTNAME>node2</HOSTNAME><HID>4</HID></HISTORY>
+</HISTORY_RECORDS>
+</VM>`, nil
+		},
+	})
+	defer standIn.Close()
+
+	vm, err := findVmById(client, "7")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if vm.DeployId != "one-7" || vm.STime != 1500000000 {
+		t.Errorf("Expected deploy ID one-7 and start time 1500000000, got %s and %d", vm.DeployId, vm.STime)
+	}
+	if vm.Monitoring == nil || vm.Monitoring.CPU != 12.5 || vm.Monitoring.Memory != 524288 || vm.Monitoring.NetTX != 1024 || vm.Monitoring.NetRX != 2048 {
+		t.Errorf("Expected the monitoring values to be read, got %+v", vm.Monitoring)
+	}
+	if vm.VmTemplate.CPU != 0.5 || vm.VmTemplate.VCPU != 2 || vm.VmTemplate.Memory != 1024 {
+		t.Errorf("Expected the allocated capacity to be read, got %+v", vm.VmTemplate)
+	}
+	if vm.VmTemplate.Graphics == nil || vm.VmTemplate.Graphics.Port != 5907 {
+		t.Errorf("Expected VNC port 5907, got %+v", vm.VmTemplate.Graphics)
+	}
+
+	// the VM is deployed where its last history record says
+	if host, hostId := vmHost(vm); host != "node2" || hostId != 4 {
+		t.Errorf("Expected the VM to be on host node2 (4), got %s (%d)", host, hostId)
+	}
+	if host, hostId := vmHost(&UserVm{}); host != "" || hostId != -1 {
+		t.Errorf("Expected a VM that was never deployed to have no host, got %s (%d)", host, hostId)
+	}
+
+	nics, ips, macs := vmNics(vm.VmTemplate)
+	if len(nics) != 3 || nics[2]["parent"] != "NIC0" || nics[2]["ip"] != "10.0.0.6" || nics[1]["ip6_global"] != "2001:db8::5" {
+		t.Errorf("Expected both NICs and the alias, got %v", nics)
+	}
+
+	expectedIps := []string{"10.0.0.5", "2001:db8::5", "fe80::5", "10.0.0.6"}
+	if !reflect.DeepEqual(ips, expectedIps) {
+		t.Errorf("Expected IPs %v, got %v", expectedIps, ips)
+	}
+	expectedMacs := []string{"02:00:0a:00:00:05", "02:00:0a:00:01:05", "02:00:0a:00:00:06"}
+	if !reflect.DeepEqual(macs, expectedMacs) {
+		t.Errorf("Expected MACs %v, got %v", expectedMacs, macs)
+	}
+
+	if ip := vmIP(vm.VmTemplate); ip != "10.0.0.5" {
+		t.Errorf("Expected the context IP 10.0.0.5, got %s", ip)
+	}
+	if ip := vmIP(&VmTemplate{NICs: vm.VmTemplate.NICs[1:]}); ip != "" {
+		t.Errorf("Expected no IP without context and IPv4 on the first NIC, got %s", ip)
+	}
+}
@@ -4,8 +4,12 @@ import (
 	"encoding/xml"
 	"fmt"
 	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
+	"github.com/runtastic/terraform-provider-opennebula/opennebula/template"
 	"log"
 	"net"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -15,18 +19,87 @@ type UserVnets struct {
 }
 
 type UserVnet struct {
-	Name        string       `xml:"NAME"`
-	Id          int          `xml:"ID"`
-	Uid         int          `xml:"UID"`
-	Gid         int          `xml:"GID"`
-	Uname       string       `xml:"UNAME"`
-	Gname       string       `xml:"GNAME"`
-	Permissions *Permissions `xml:"PERMISSIONS"`
-	Bridge      string       `xml:"BRIDGE"`
+	Name          string              `xml:"NAME"`
+	Id            int                 `xml:"ID"`
+	Uid           int                 `xml:"UID"`
+	Gid           int                 `xml:"GID"`
+	Uname         string              `xml:"UNAME"`
+	Gname         string              `xml:"GNAME"`
+	Permissions   *Permissions        `xml:"PERMISSIONS"`
+	Lock          *Lock               `xml:"LOCK"`
+	Bridge        string              `xml:"BRIDGE"`
+	VnMad         string              `xml:"VN_MAD"`
+	PhyDev        string              `xml:"PHYDEV"`
+	VlanId        string              `xml:"VLAN_ID"`
+	AutoVlanId    string              `xml:"VLAN_ID_AUTOMATIC"`
+	Clusters      []int               `xml:"CLUSTERS>ID"`
+	AddressRanges []*VnetAddressRange `xml:"AR_POOL>AR"`
+	ParentId      string              `xml:"PARENT_NETWORK_ID"`
+	Template      *VnetTemplate       `xml:"TEMPLATE"`
 }
 
+type VnetTemplate struct {
+	Mtu            string `xml:"MTU"`
+	Gateway        string `xml:"GATEWAY"`
+	Dns            string `xml:"DNS"`
+	NetworkMask    string `xml:"NETWORK_MASK"`
+	GuestMtu       string `xml:"GUEST_MTU"`
+	SecurityGroups string `xml:"SECURITY_GROUPS"`
+}
+
+// vnetMode describes what the networking driver of a vnet (VN_MAD) needs
+type vnetMode struct {
+	needsPhyDev bool
+	needsVlan   bool
+	allowsVlan  bool
+}
+
+var vnetModes = map[string]vnetMode{
+	"dummy":          {},
+	"bridge":         {},
+	"fw":             {},
+	"ebtables":       {},
+	"802.1Q":         {needsPhyDev: true, needsVlan: true, allowsVlan: true},
+	"vxlan":          {needsPhyDev: true, needsVlan: true, allowsVlan: true},
+	"ovswitch":       {allowsVlan: true},
+	"ovswitch_vxlan": {needsPhyDev: true, needsVlan: true, allowsVlan: true},
+}
+
+type VnetAddressRange struct {
+	Id           int             `xml:"AR_ID"`
+	Type         string          `xml:"TYPE"`
+	Ip           string          `xml:"IP"`
+	Ip6          string          `xml:"IP6"`
+	Mac          string          `xml:"MAC"`
+	Size         int             `xml:"SIZE"`
+	GlobalPrefix string          `xml:"GLOBAL_PREFIX"`
+	UlaPrefix    string          `xml:"ULA_PREFIX"`
+	PrefixLength int             `xml:"PREFIX_LENGTH"`
+	ParentArId   string          `xml:"PARENT_NETWORK_AR_ID"`
+	Leases       []*VnetLease    `xml:"LEASES>LEASE"`
+	Attributes   []VnetAttribute `xml:",any"`
+}
+
+type VnetLease struct {
+	Ip        string `xml:"IP"`
+	Ip6       string `xml:"IP6"`
+	Ip6Global string `xml:"IP6_GLOBAL"`
+	Ip6Ula    string `xml:"IP6_ULA"`
+	Mac       string `xml:"MAC"`
+	Vm        *int   `xml:"VM"`
+	Vnet      *int   `xml:"VNET"`
+	Vrouter   *int   `xml:"VROUTER"`
+}
+
+type VnetAttribute struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+var vnetAddressRangeTypes = []string{"IP4", "IP6", "IP4_6", "IP6_STATIC", "ETHER"}
+
 func resourceVnet() *schema.Resource {
-	return &schema.Resource{
+	resource := &schema.Resource{
 		Create: resourceVnetCreate,
 		Read:   resourceVnetRead,
 		Exists: resourceVnetExists,
@@ -43,9 +116,20 @@ func resourceVnet() *schema.Resource {
 				Description: "Name of the vnet",
 			},
 			"description": {
-				Type:        schema.TypeString,
-				Required:    true,
-				Description: "Description of the vnet, in OpenNebula's XML or String format",
+				Type:             schema.TypeString,
+				Optional:         true,
+				Default:          "",
+				Description:      "Description of the vnet, in OpenNebula's XML or String format",
+				ValidateFunc:     validateTemplate,
+				DiffSuppressFunc: suppressEquivalentTemplates,
+			},
+			"template_id": {
+				Type:          schema.TypeInt,
+				Optional:      true,
+				ForceNew:      true,
+				Default:       -1,
+				Description:   "ID of the vnet template to instantiate the vnet from, with its own address ranges",
+				ConflictsWith: []string{"ip_start", "ip_size", "address_range"},
 			},
 			"permissions": {
 				Type:        schema.TypeString,
@@ -92,131 +176,439 @@ func resourceVnet() *schema.Resource {
 				Computed:    true,
 				Description: "Name of the group that will own the vnet",
 			},
-			"bridge": {
-				Type:        schema.TypeString,
-				Required:    true,
-				Description: "Name of the bridge interface to which the vnet should be associated",
-			},
 			"ip_start": {
-				Type:        schema.TypeString,
-				Required:    true,
-				Description: "Start IP of the range to be allocated",
+				Type:          schema.TypeString,
+				Optional:      true,
+				Description:   "Start IP of the range to be allocated",
+				Deprecated:    "Use address_range instead",
+				ConflictsWith: []string{"address_range"},
 			},
 			"ip_size": {
-				Type:        schema.TypeInt,
-				Required:    true,
-				Description: "Size (in number) of the ip range",
+				Type:          schema.TypeInt,
+				Optional:      true,
+				Description:   "Size (in number) of the ip range",
+				Deprecated:    "Use address_range instead",
+				ConflictsWith: []string{"address_range"},
+			},
+			"address_range": {
+				Type:          schema.TypeList,
+				Optional:      true,
+				Computed:      true,
+				Description:   "Address ranges of the vnet. Changing the addresses of a range replaces it",
+				ConflictsWith: []string{"ip_start", "ip_size"},
+				Elem: &schema.Resource{
+					Schema: vnetAddressRangeSchema(),
+				},
 			},
 			"reservation_size": {
 				Type:        schema.TypeInt,
 				Optional:    true,
-				Description: "Carve a network reservation of this size from the reservation starting from `ip-start`",
+				Description: "Carve a network reservation of this size from the reservation starting from `ip-start`, or from the first address range",
+				Deprecated:  "Use hold_ips, or opennebula_vnet_reservation to carve a network out of this one",
 			},
+			"hold_ips": {
+				Type:        schema.TypeSet,
+				Optional:    true,
+				Description: "IP addresses of the vnet to put on hold, so that they aren't leased to VMs",
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validateIP,
+				},
+			},
+			"leases": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "Addresses of the vnet in use, either by VMs, virtual routers, reservations or on hold",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"ar_id": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"ip": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"ip6": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"ip6_global": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"ip6_ula": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"mac": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"vm_id": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "ID of the VM using the address, -1 if none",
+						},
+						"vnet_id": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "ID of the reservation using the address, -1 if none",
+						},
+						"vrouter_id": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "ID of the virtual router using the address, -1 if none",
+						},
+						"held": {
+							Type:        schema.TypeBool,
+							Computed:    true,
+							Description: "Whether the address is on hold",
+						},
+					},
+				},
+			},
+			"lock": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "Lock level of the vnet: USE, MANAGE, ADMIN or ALL. Locked vnets can't be destroyed",
+				ValidateFunc: validation.StringInSlice([]string{"USE", "MANAGE", "ADMIN", "ALL"}, false),
+			},
+			"adopt_by_name": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "If the vnet can't be found by ID, adopt the only vnet of the user with the same name",
+			},
+		},
+	}
+
+	for k, v := range vnetNetworkSchema() {
+		resource.Schema[k] = v
+	}
+
+	return resource
+}
+
+// vnetNetworkSchema describes the networking attributes shared by vnets and vnet templates
+func vnetNetworkSchema() map[string]*schema.Schema {
+	return map[string]*schema.Schema{
+		"bridge": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Computed:    true,
+			Description: "Name of the bridge interface to which the vnet should be associated",
+		},
+		"vn_mad": {
+			Type:         schema.TypeString,
+			Optional:     true,
+			Computed:     true,
+			ForceNew:     true,
+			Description:  "Networking driver of the vnet: bridge, fw, ebtables, 802.1Q, vxlan, ovswitch or ovswitch_vxlan",
+			ValidateFunc: validation.StringInSlice([]string{"dummy", "bridge", "fw", "ebtables", "802.1Q", "vxlan", "ovswitch", "ovswitch_vxlan"}, false),
+		},
+		"phydev": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Computed:    true,
+			Description: "Physical interface of the hosts the vnet is attached to. Needed by 802.1Q, vxlan and ovswitch_vxlan",
+		},
+		"vlan_id": {
+			Type:          schema.TypeString,
+			Optional:      true,
+			Computed:      true,
+			Description:   "VLAN ID of the vnet, for 802.1Q, vxlan, ovswitch and ovswitch_vxlan",
+			ConflictsWith: []string{"automatic_vlan_id"},
+		},
+		"automatic_vlan_id": {
+			Type:          schema.TypeBool,
+			Optional:      true,
+			Computed:      true,
+			Description:   "Let OpenNebula pick the VLAN ID of the vnet",
+			ConflictsWith: []string{"vlan_id"},
+		},
+		"mtu": {
+			Type:        schema.TypeInt,
+			Optional:    true,
+			Computed:    true,
+			Description: "MTU of the interfaces the vnet creates on the hosts",
+		},
+		"guest_mtu": {
+			Type:        schema.TypeInt,
+			Optional:    true,
+			Computed:    true,
+			Description: "MTU of the interfaces of the VMs",
+		},
+		"gateway": {
+			Type:         schema.TypeString,
+			Optional:     true,
+			Computed:     true,
+			Description:  "Default gateway of the vnet",
+			ValidateFunc: validateIP,
+		},
+		"dns": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Computed:    true,
+			Description: "Space separated list of the DNS servers of the vnet",
+			ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+				for _, server := range strings.Fields(v.(string)) {
+					if _, err := parseIP(server); err != nil {
+						errors = append(errors, fmt.Errorf("%q: %s", k, err))
+					}
+				}
+				return
+			},
+		},
+		"network_mask": {
+			Type:         schema.TypeString,
+			Optional:     true,
+			Computed:     true,
+			Description:  "Network mask of the vnet, e.g. 255.255.255.0",
+			ValidateFunc: validateIP,
+		},
+		"security_groups": {
+			Type:        schema.TypeList,
+			Optional:    true,
+			Computed:    true,
+			Description: "IDs of the security groups of the vnet. OpenNebula adds the default one if not set",
+			Elem:        &schema.Schema{Type: schema.TypeInt},
+		},
+		"cluster_ids": {
+			Type:        schema.TypeSet,
+			Optional:    true,
+			Computed:    true,
+			Description: "IDs of the clusters the vnet belongs to. The default cluster if not set",
+			Elem:        &schema.Schema{Type: schema.TypeInt},
+		},
+	}
+}
+
+// addressRangeSchema describes an address range, as shared by vnets and vnet templates
+func addressRangeSchema() map[string]*schema.Schema {
+	return map[string]*schema.Schema{
+		"type": {
+			Type:         schema.TypeString,
+			Required:     true,
+			Description:  "Type of the address range: IP4, IP6, IP4_6, IP6_STATIC or ETHER",
+			ValidateFunc: validation.StringInSlice(vnetAddressRangeTypes, false),
+		},
+		"ip": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "First IPv4 address of the range, for IP4 and IP4_6 ranges",
+		},
+		"ip6": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "First IPv6 address of the range, for IP6_STATIC ranges",
+		},
+		"prefix_length": {
+			Type:        schema.TypeInt,
+			Optional:    true,
+			Description: "Length of the IPv6 prefix, for IP6_STATIC ranges",
+		},
+		"mac": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Computed:    true,
+			Description: "First MAC address of the range. OpenNebula generates one if not set",
+		},
+		"size": {
+			Type:        schema.TypeInt,
+			Required:    true,
+			Description: "Number of addresses of the range",
+		},
+		"global_prefix": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "Global IPv6 prefix, for IP6 and IP4_6 ranges",
+		},
+		"ula_prefix": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "Unique local IPv6 prefix, for IP6 and IP4_6 ranges",
+		},
+		"attributes": {
+			Type:         schema.TypeMap,
+			Optional:     true,
+			Description:  "Additional attributes of the range, such as GATEWAY or DNS",
+			Elem:         &schema.Schema{Type: schema.TypeString},
+			ValidateFunc: validateTemplateKeys,
 		},
 	}
 }
 
+// vnetAddressRangeSchema describes an address range of an existing vnet, which has an ID
+func vnetAddressRangeSchema() map[string]*schema.Schema {
+	s := addressRangeSchema()
+	s["ar_id"] = &schema.Schema{
+		Type:        schema.TypeInt,
+		Computed:    true,
+		Description: "ID of the address range within the vnet",
+	}
+
+	return s
+}
+
 func resourceVnetCreate(d *schema.ResourceData, meta interface{}) error {
 	client := meta.(*Client)
-	// Create base object
-	resp, err := client.Call(
-		"one.vn.allocate",
-		fmt.Sprintf("NAME = \"%s\"\n", d.Get("name").(string))+d.Get("description").(string)+"\nBRIDGE="+d.Get("bridge").(string),
-		-1,
-	)
+
+	if err := validateVnetMode(d); err != nil {
+		return err
+	}
+
+	// Create base object, in the first cluster if any, or the default one
+	clusters := d.Get("cluster_ids").(*schema.Set).List()
+	cluster := -1
+	if len(clusters) > 0 {
+		cluster = clusters[0].(int)
+	}
+
+	tmpl, err := vnetTemplate(d)
 	if err != nil {
 		return err
 	}
 
-	d.SetId(resp)
+	if d.Get("template_id").(int) >= 0 {
+		if err = instantiateVnetTemplate(d, client, tmpl, clusters); err != nil {
+			return err
+		}
+	} else {
+		resp, err := client.Call(
+			"one.vn.allocate",
+			template.New().Add("NAME", d.Get("name").(string)).Append(tmpl.Attributes...).String(),
+			cluster,
+		)
+		if err != nil {
+			return err
+		}
+
+		d.SetId(resp)
+
+		if len(clusters) > 1 {
+			if err = changeClusters(client, "vnet", intId(d.Id()), clusters[:1], clusters); err != nil {
+				return err
+			}
+		}
+	}
+
 	// update permisions
 	if _, err = changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.vn.chmod"); err != nil {
 		return err
 	}
-	// add address range and reservations
-	var address_range_string = `AR = [
-  TYPE = IP4,
-  IP = %s,
-  SIZE = %d ]`
-	_, a_err := client.Call(
-		"one.vn.add_ar",
-		intId(d.Id()),
-		fmt.Sprintf(address_range_string, d.Get("ip_start").(string), d.Get("ip_size").(int)),
-	)
+	// add address ranges and reservations
+	if ipStart, ok := d.GetOk("ip_start"); ok {
+		_, a_err := client.Call(
+			"one.vn.add_ar",
+			intId(d.Id()),
+			template.NewVector("AR").Add("TYPE", "IP4").Add("IP", ipStart.(string)).Add("SIZE", d.Get("ip_size").(int)).String(),
+		)
 
-	if a_err != nil {
-		return a_err
+		if a_err != nil {
+			return a_err
+		}
+	} else {
+		ars, err := updateAddressRanges(client, intId(d.Id()), nil, d.Get("address_range").([]interface{}))
+		if err != nil {
+			return err
+		}
+		if err = d.Set("address_range", ars); err != nil {
+			return err
+		}
 	}
 
-	if d.Get("reservation_size").(int) > 0 {
-		// add address range and reservations
-		ip := net.ParseIP(d.Get("ip_start").(string))
-		ip = ip.To4()
-
-		for i := 0; i < d.Get("reservation_size").(int); i++ {
-			var address_reservation_string = `LEASES=[IP=%s]`
-			_, r_err := client.Call(
-				"one.vn.hold",
-				intId(d.Id()),
-				fmt.Sprintf(address_reservation_string, ip),
-			)
-
-			if r_err != nil {
-				return r_err
-			}
+	if err = changeReservation(d, client, "one.vn.hold", d.Get("reservation_size").(int)); err != nil {
+		return err
+	}
 
-			ip[3]++
+	for _, ip := range d.Get("hold_ips").(*schema.Set).List() {
+		if err = changeLease(client, intId(d.Id()), "one.vn.hold", ip.(string), -1); err != nil {
+			return err
 		}
+	}
 
+	if err = changeLock(intId(d.Id()), "", d.Get("lock").(string), client, "one.vn"); err != nil {
+		return err
 	}
 
 	return resourceVnetRead(d, meta)
 }
 
+// instantiateVnetTemplate creates the vnet from template_id, with the attributes in tmpl on top of the template's,
+// and moves it from the clusters of the template to the configured ones, if any
+func instantiateVnetTemplate(d *schema.ResourceData, client *Client, tmpl *template.Template, clusters []interface{}) error {
+	var vn *UserVnet
+
+	resp, err := client.Call(
+		"one.vntemplate.instantiate",
+		d.Get("template_id").(int),
+		d.Get("name").(string),
+		tmpl.String(),
+	)
+	if err != nil {
+		return err
+	}
+
+	d.SetId(resp)
+
+	if len(clusters) == 0 {
+		return nil
+	}
+
+	resp, err = client.Call("one.vn.info", intId(d.Id()), false)
+	if err != nil {
+		return err
+	}
+	if err = xml.Unmarshal([]byte(resp), &vn); err != nil {
+		return err
+	}
+
+	current := make([]interface{}, 0, len(vn.Clusters))
+	for _, c := range vn.Clusters {
+		current = append(current, c)
+	}
+
+	return changeClusters(client, "vnet", vn.Id, current, clusters)
+}
+
 func resourceVnetRead(d *schema.ResourceData, meta interface{}) error {
 	var vn *UserVnet
-	var vns *UserVnets
 
 	client := meta.(*Client)
-	found := false
 
 	// Try to find the vnet by ID, if specified
 	if d.Id() != "" {
 		resp, err := client.Call("one.vn.info", intId(d.Id()), false)
 		if err == nil {
-			found = true
 			if err = xml.Unmarshal([]byte(resp), &vn); err != nil {
 				return err
 			}
+		} else if !isNotFound(err) {
+			return err
 		} else {
 			log.Printf("Could not find vnet by ID %s", d.Id())
 		}
 	}
 
-	// Otherwise, try to find the vnet by (user, name) as the de facto compound primary key
-	if d.Id() == "" || !found {
-		resp, err := client.Call("one.vnpool.info", -3, -1, -1)
-		if err != nil {
-			return err
+	// Otherwise, only adopt a vnet by (user, name) as the de facto compound primary key if asked to
+	if vn == nil {
+		if !d.Get("adopt_by_name").(bool) {
+			d.SetId("")
+			return nil
 		}
 
-		if err = xml.Unmarshal([]byte(resp), &vns); err != nil {
+		found, err := findVnetByName(client, d.Get("name").(string))
+		if err != nil {
 			return err
 		}
 
-		for _, t := range vns.UserVnet {
-			if t.Name == d.Get("name").(string) {
-				vn = t
-				found = true
-				break
-			}
-		}
-
-		if !found || vn == nil {
+		if found == nil {
 			d.SetId("")
 			log.Printf("Could not find vnet with name %s for user %s", d.Get("name").(string), client.Username)
 			return nil
 		}
+		vn = found
 	}
 
 	d.SetId(strconv.Itoa(vn.Id))
@@ -226,11 +618,613 @@ func resourceVnetRead(d *schema.ResourceData, meta interface{}) error {
 	d.Set("uname", vn.Uname)
 	d.Set("gname", vn.Gname)
 	d.Set("bridge", vn.Bridge)
+	d.Set("vn_mad", vn.VnMad)
+	d.Set("phydev", vn.PhyDev)
+	d.Set("vlan_id", vn.VlanId)
+	d.Set("automatic_vlan_id", vn.AutoVlanId == "1")
+	if vn.Template != nil {
+		mtu, _ := strconv.Atoi(vn.Template.Mtu)
+		guestMtu, _ := strconv.Atoi(vn.Template.GuestMtu)
+		d.Set("mtu", mtu)
+		d.Set("guest_mtu", guestMtu)
+		d.Set("gateway", vn.Template.Gateway)
+		d.Set("dns", vn.Template.Dns)
+		d.Set("network_mask", vn.Template.NetworkMask)
+		if err := d.Set("security_groups", splitInts(vn.Template.SecurityGroups)); err != nil {
+			return err
+		}
+	}
 	d.Set("permissions", permissionString(vn.Permissions))
+	d.Set("lock", lockLevelName(vn.Lock))
+
+	if err := d.Set("cluster_ids", vn.Clusters); err != nil {
+		return err
+	}
+
+	if err := d.Set("leases", vnetLeases(vn)); err != nil {
+		return err
+	}
+
+	// only keep the addresses that are still on hold, so that released ones are held again
+	held := heldIps(vn)
+	holdIps := make([]interface{}, 0)
+	for _, ip := range d.Get("hold_ips").(*schema.Set).List() {
+		if parsed, err := parseIP(ip.(string)); err == nil && held[parsed.String()] {
+			holdIps = append(holdIps, ip)
+		}
+	}
+	if err := d.Set("hold_ips", holdIps); err != nil {
+		return err
+	}
+
+	if err := d.Set("address_range", vnetAddressRanges(vn, d.Get("address_range").([]interface{}))); err != nil {
+		return err
+	}
 
 	return nil
 }
 
+// vnetAddressRanges lists the address ranges of a vnet in the order of the known ones, matched by ID, as
+// OpenNebula lists them by ID instead. The ranges that aren't known yet come last
+func vnetAddressRanges(vn *UserVnet, known []interface{}) []map[string]interface{} {
+	byId := make(map[int]*VnetAddressRange)
+	for _, ar := range vn.AddressRanges {
+		byId[ar.Id] = ar
+	}
+
+	ars := make([]map[string]interface{}, 0, len(vn.AddressRanges))
+	for _, k := range known {
+		if k == nil {
+			continue
+		}
+		prev := k.(map[string]interface{})
+		if ar, ok := byId[prev["ar_id"].(int)]; ok {
+			ars = append(ars, addressRangeAttributes(ar, prev["attributes"].(map[string]interface{})))
+			delete(byId, ar.Id)
+		}
+	}
+
+	for _, ar := range vn.AddressRanges {
+		if _, ok := byId[ar.Id]; ok {
+			ars = append(ars, addressRangeAttributes(ar, nil))
+		}
+	}
+
+	return ars
+}
+
+// addressRangeAttributes converts an address range read from OpenNebula into the attributes of an
+// address_range block. Only the additional attributes in keys are kept, as OpenNebula adds many of its own.
+// OpenNebula upper-cases their names, so they keep the spelling of keys
+func addressRangeAttributes(ar *VnetAddressRange, keys map[string]interface{}) map[string]interface{} {
+	attributes := make(map[string]interface{})
+	for _, attr := range ar.Attributes {
+		for key := range keys {
+			if strings.EqualFold(key, attr.XMLName.Local) {
+				attributes[key] = attr.Value
+			}
+		}
+	}
+
+	return map[string]interface{}{
+		"ar_id":         ar.Id,
+		"type":          ar.Type,
+		"ip":            ar.Ip,
+		"ip6":           ar.Ip6,
+		"prefix_length": ar.PrefixLength,
+		"mac":           ar.Mac,
+		"size":          ar.Size,
+		"global_prefix": ar.GlobalPrefix,
+		"ula_prefix":    ar.UlaPrefix,
+		"attributes":    attributes,
+	}
+}
+
+// addressRangeTemplate renders an address_range block as an AR vector attribute. Ranges that
+// already exist are identified by their ID and only carry the attributes that can be updated in place
+func addressRangeTemplate(ar map[string]interface{}, arId int) (*template.Attribute, error) {
+	arType := ar["type"].(string)
+	vector := template.NewVector("AR")
+
+	if arId < 0 {
+		vector.Add("TYPE", arType)
+
+		ip := ar["ip"].(string)
+		switch {
+		case (arType == "IP4" || arType == "IP4_6") && ip == "":
+			return nil, fmt.Errorf("Address ranges of type %s need an ip", arType)
+		case arType != "IP4" && arType != "IP4_6" && ip != "":
+			return nil, fmt.Errorf("Address ranges of type %s can't have an ip", arType)
+		case ip != "":
+			vector.Add("IP", ip)
+		}
+
+		ip6 := ar["ip6"].(string)
+		prefixLength := ar["prefix_length"].(int)
+		switch {
+		case arType == "IP6_STATIC" && (ip6 == "" || prefixLength == 0):
+			return nil, fmt.Errorf("Address ranges of type IP6_STATIC need an ip6 and a prefix_length")
+		case arType != "IP6_STATIC" && (ip6 != "" || prefixLength != 0):
+			return nil, fmt.Errorf("Address ranges of type %s can't have an ip6 or a prefix_length", arType)
+		case arType == "IP6_STATIC":
+			vector.Add("IP6", ip6).Add("PREFIX_LENGTH", prefixLength)
+		}
+
+		if mac := ar["mac"].(string); mac != "" {
+			vector.Add("MAC", mac)
+		}
+	} else {
+		vector.Add("AR_ID", arId)
+	}
+
+	vector.Add("SIZE", ar["size"].(int))
+
+	for _, key := range []string{"global_prefix", "ula_prefix"} {
+		value := ar[key].(string)
+		if value == "" {
+			continue
+		}
+		if arType != "IP6" && arType != "IP4_6" {
+			return nil, fmt.Errorf("Address ranges of type %s can't have a %s", arType, key)
+		}
+		vector.Add(strings.ToUpper(key), value)
+	}
+
+	keys := make([]string, 0)
+	for k := range ar["attributes"].(map[string]interface{}) {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		vector.Add(k, ar["attributes"].(map[string]interface{})[k])
+	}
+
+	// the keys of attributes are the configuration's
+	if err := vector.Validate(); err != nil {
+		return nil, err
+	}
+
+	return vector, nil
+}
+
+// addressRangeMoved tells whether the addresses of a range changed, which OpenNebula can only do by replacing it
+func addressRangeMoved(old, new map[string]interface{}) bool {
+	for _, key := range []string{"type", "ip", "ip6", "prefix_length"} {
+		if old[key] != new[key] {
+			return true
+		}
+	}
+
+	// the MAC is generated by OpenNebula unless set
+	return new["mac"].(string) != "" && old["mac"] != new["mac"]
+}
+
+// addressRangeChanged tells whether the attributes of a range that can be updated in place changed
+func addressRangeChanged(old, new map[string]interface{}) bool {
+	for _, key := range []string{"size", "global_prefix", "ula_prefix"} {
+		if old[key] != new[key] {
+			return true
+		}
+	}
+
+	return !reflect.DeepEqual(old["attributes"], new["attributes"])
+}
+
+// matchAddressRanges pairs each range of new with the range of old it updates, or -1 if it is a new range.
+// Ranges are matched by their addresses, preferably at the same position, so that removing or replacing a
+// range doesn't move the ones after it. Away from their position, the MAC and ID of new are the ones carried
+// over from the range that was there before, so they don't take part in the match
+func matchAddressRanges(old, new []interface{}) []int {
+	matches := make([]int, len(new))
+	matched := make(map[int]bool)
+
+	for i, n := range new {
+		matches[i] = -1
+		if i < len(old) && !addressRangeMoved(old[i].(map[string]interface{}), n.(map[string]interface{})) {
+			matches[i] = i
+			matched[i] = true
+		}
+	}
+
+	for i, n := range new {
+		if matches[i] >= 0 {
+			continue
+		}
+		ar := n.(map[string]interface{})
+		for j, o := range old {
+			prev := o.(map[string]interface{})
+			if !matched[j] && !addressRangeMoved(prev, addressRangeWithMac(ar, prev["mac"])) {
+				matches[i] = j
+				matched[j] = true
+				break
+			}
+		}
+	}
+
+	return matches
+}
+
+// addressRangeWithMac is a copy of the address_range block ar with another MAC
+func addressRangeWithMac(ar map[string]interface{}, mac interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(ar))
+	for k, v := range ar {
+		copied[k] = v
+	}
+	copied["mac"] = mac
+
+	return copied
+}
+
+// updateAddressRanges reconciles the address ranges of a vnet one by one and returns the ranges of the
+// configuration with their IDs in OpenNebula. Ranges are removed first, so that their addresses can be reused
+func updateAddressRanges(client *Client, id int, old, new []interface{}) ([]interface{}, error) {
+	matches := matchAddressRanges(old, new)
+	removed := make([]int, 0)
+	added := make([]int, 0)
+	updated := make([]int, 0)
+
+	kept := make(map[int]bool)
+	for _, j := range matches {
+		if j >= 0 {
+			kept[j] = true
+		}
+	}
+
+	// render the new ranges before touching the vnet, so that invalid ones don't leave it half updated
+	templates := make([]string, len(new))
+	for i, n := range new {
+		ar := n.(map[string]interface{})
+		if j := matches[i]; j >= 0 {
+			if addressRangeChanged(old[j].(map[string]interface{}), ar) {
+				updated = append(updated, i)
+			}
+			continue
+		}
+
+		// a range added where a kept one was before carries over its MAC, which is still in use
+		if i < len(old) && kept[i] && ar["mac"] == old[i].(map[string]interface{})["mac"] {
+			ar = addressRangeWithMac(ar, "")
+		}
+
+		tmpl, err := addressRangeTemplate(ar, -1)
+		if err != nil {
+			return nil, err
+		}
+		templates[i] = tmpl.String()
+		added = append(added, i)
+	}
+
+	for j, o := range old {
+		if !kept[j] {
+			removed = append(removed, o.(map[string]interface{})["ar_id"].(int))
+		}
+	}
+
+	for _, arId := range removed {
+		if _, err := client.Call("one.vn.rm_ar", id, arId); err != nil {
+			return nil, err
+		}
+		log.Printf("[INFO] Successfully removed address range %d from Vnet %d\n", arId, id)
+	}
+
+	for _, i := range added {
+		if _, err := client.Call("one.vn.add_ar", id, templates[i]); err != nil {
+			return nil, err
+		}
+		log.Printf("[INFO] Successfully added address range to Vnet %d\n", id)
+	}
+
+	// the ranges kept have the ID and MAC they had, the ones added get the ones OpenNebula gave them
+	ars := make([]interface{}, len(new))
+	for i, n := range new {
+		if j := matches[i]; j >= 0 {
+			prev := old[j].(map[string]interface{})
+			ar := addressRangeWithMac(n.(map[string]interface{}), prev["mac"])
+			ar["ar_id"] = prev["ar_id"]
+			ars[i] = ar
+		}
+	}
+
+	if len(added) > 0 {
+		addedArs, err := addedAddressRanges(client, id, old, removed, len(added))
+		if err != nil {
+			return nil, err
+		}
+		for k, i := range added {
+			ar := addressRangeWithMac(new[i].(map[string]interface{}), addedArs[k].Mac)
+			ar["ar_id"] = addedArs[k].Id
+			ars[i] = ar
+		}
+	}
+
+	for _, i := range updated {
+		arId := ars[i].(map[string]interface{})["ar_id"].(int)
+		tmpl, err := addressRangeTemplate(new[i].(map[string]interface{}), arId)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = client.Call("one.vn.update_ar", id, tmpl.String()); err != nil {
+			return nil, err
+		}
+		log.Printf("[INFO] Successfully updated address range %d of Vnet %d\n", arId, id)
+	}
+
+	return ars, nil
+}
+
+// addedAddressRanges reads back the count address ranges just added to a vnet, in the order they were added, as
+// one.vn.add_ar doesn't return their IDs. OpenNebula gives each new range a higher ID than the previous ones
+func addedAddressRanges(client *Client, id int, old []interface{}, removed []int, count int) ([]*VnetAddressRange, error) {
+	resp, err := client.Call("one.vn.info", id, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var vn UserVnet
+	if err = xml.Unmarshal([]byte(resp), &vn); err != nil {
+		return nil, err
+	}
+
+	existing := make(map[int]bool)
+	for _, o := range old {
+		existing[o.(map[string]interface{})["ar_id"].(int)] = true
+	}
+	for _, arId := range removed {
+		delete(existing, arId)
+	}
+
+	added := make([]*VnetAddressRange, 0, count)
+	for _, ar := range vn.AddressRanges {
+		if !existing[ar.Id] {
+			added = append(added, ar)
+		}
+	}
+	if len(added) != count {
+		return nil, fmt.Errorf("Expected %d new address ranges in Vnet %d, found %d", count, id, len(added))
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i].Id < added[j].Id })
+
+	return added, nil
+}
+
+// vnetReservationRange is the address range the reservation is carved from: the one of ip_start and
+// ip_size, or the first address range
+func vnetReservationRange(d *schema.ResourceData) (net.IP, int, error) {
+	if ipStart, ok := d.GetOk("ip_start"); ok {
+		first, err := parseIP(ipStart.(string))
+		return first, d.Get("ip_size").(int), err
+	}
+
+	ars := d.Get("address_range").([]interface{})
+	if len(ars) == 0 || ars[0] == nil {
+		return nil, 0, fmt.Errorf("Vnet %s has no address range to carve the reservation from", d.Id())
+	}
+
+	ar := ars[0].(map[string]interface{})
+	switch ar["type"].(string) {
+	case "IP4", "IP4_6":
+		first, err := parseIP(ar["ip"].(string))
+		return first, ar["size"].(int), err
+	case "IP6_STATIC":
+		first, err := parseIP(ar["ip6"].(string))
+		return first, ar["size"].(int), err
+	}
+
+	return nil, 0, fmt.Errorf("Can't carve a reservation from an address range of type %s, as it has no fixed IP addresses", ar["type"].(string))
+}
+
+// changeReservation holds or releases (method one.vn.hold or one.vn.release) the first count
+// addresses of the vnet
+func changeReservation(d *schema.ResourceData, client *Client, method string, count int) error {
+	if count <= 0 {
+		return nil
+	}
+
+	first, size, err := vnetReservationRange(d)
+	if err != nil {
+		return err
+	}
+
+	if !ipRangeContains(first, size, first, count) {
+		return fmt.Errorf("A reservation of %d addresses doesn't fit in the %d addresses from %s", count, size, first)
+	}
+
+	for i := 0; i < count; i++ {
+		ip, err := ipAdd(first, i)
+		if err != nil {
+			return err
+		}
+
+		if err = changeLease(client, intId(d.Id()), method, ip.String(), -1); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("[INFO] Successfully called %s on %d addresses from %s\n", method, count, first)
+	return nil
+}
+
+// changeLease holds or releases (method one.vn.hold or one.vn.release) a single address of a vnet,
+// optionally within a given address range (arId >= 0)
+func changeLease(client *Client, id int, method, address string, arId int) error {
+	ip, err := parseIP(address)
+	if err != nil {
+		return err
+	}
+
+	lease := template.NewVector("LEASES").Add(ipLeaseAttribute(ip), ip)
+	if arId >= 0 {
+		lease.Add("AR_ID", arId)
+	}
+
+	if _, err = client.Call(method, id, lease.String()); err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Successfully called %s on %s of Vnet %d\n", method, ip, id)
+	return nil
+}
+
+// vnetLeases lists the addresses in use in the address ranges of a vnet
+func vnetLeases(vn *UserVnet) []map[string]interface{} {
+	leases := make([]map[string]interface{}, 0)
+	for _, ar := range vn.AddressRanges {
+		for _, l := range ar.Leases {
+			vm := intOrNone(l.Vm)
+			leases = append(leases, map[string]interface{}{
+				"ar_id":      ar.Id,
+				"ip":         l.Ip,
+				"ip6":        l.Ip6,
+				"ip6_global": l.Ip6Global,
+				"ip6_ula":    l.Ip6Ula,
+				"mac":        l.Mac,
+				"vm_id":      vm,
+				"vnet_id":    intOrNone(l.Vnet),
+				"vrouter_id": intOrNone(l.Vrouter),
+				// OpenNebula marks held addresses as used by VM -1
+				"held": l.Vm != nil && vm == -1,
+			})
+		}
+	}
+
+	return leases
+}
+
+// heldIps lists the addresses of leases that are on hold, in their canonical form
+func heldIps(vn *UserVnet) map[string]bool {
+	held := make(map[string]bool)
+	for _, l := range vnetLeases(vn) {
+		if !l["held"].(bool) {
+			continue
+		}
+		for _, key := range []string{"ip", "ip6"} {
+			if ip, err := parseIP(l[key].(string)); err == nil {
+				held[ip.String()] = true
+			}
+		}
+	}
+
+	return held
+}
+
+func intOrNone(i *int) int {
+	if i == nil {
+		return -1
+	}
+
+	return *i
+}
+
+// vnetTemplate renders the description of the vnet along with its networking attributes
+func vnetTemplate(d *schema.ResourceData) (*template.Template, error) {
+	return renderVnetTemplate(d.Get)
+}
+
+// renderVnetTemplate renders the template of a vnet out of the fields get reads, so that both the
+// current and the previous template of a vnet can be rendered
+func renderVnetTemplate(get func(string) interface{}) (*template.Template, error) {
+	tmpl, err := template.Parse(get("description").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if bridge := get("bridge").(string); bridge != "" {
+		tmpl.Add("BRIDGE", bridge)
+	}
+
+	for _, attr := range []struct {
+		field string
+		key   string
+	}{
+		{"vn_mad", "VN_MAD"},
+		{"phydev", "PHYDEV"},
+		{"vlan_id", "VLAN_ID"},
+		{"mtu", "MTU"},
+		{"guest_mtu", "GUEST_MTU"},
+		{"gateway", "GATEWAY"},
+		{"dns", "DNS"},
+		{"network_mask", "NETWORK_MASK"},
+	} {
+		// an automatic VLAN ID is picked by OpenNebula, so it isn't sent back as VLAN_ID
+		if attr.field == "vlan_id" && get("automatic_vlan_id").(bool) {
+			tmpl.Add("AUTOMATIC_VLAN_ID", "YES")
+			continue
+		}
+		if v := get(attr.field); v != "" && v != 0 {
+			tmpl.Add(attr.key, v)
+		}
+	}
+
+	if groups := get("security_groups").([]interface{}); len(groups) > 0 {
+		tmpl.Add("SECURITY_GROUPS", joinInts(groups))
+	}
+
+	return tmpl, nil
+}
+
+// vnetAttributesChanged tells whether the typed attributes rendered by vnetTemplate changed
+func vnetAttributesChanged(d *schema.ResourceData) bool {
+	for _, field := range []string{"phydev", "vlan_id", "automatic_vlan_id", "mtu", "guest_mtu", "gateway", "dns", "network_mask", "security_groups"} {
+		if d.HasChange(field) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateVnetMode checks that the physical device and VLAN of the vnet suit its networking driver
+func validateVnetMode(d *schema.ResourceData) error {
+	mad := d.Get("vn_mad").(string)
+	mode, ok := vnetModes[mad]
+	if !ok {
+		// the driver is part of the description
+		return nil
+	}
+
+	_, vlan := d.GetOk("vlan_id")
+	vlan = vlan || d.Get("automatic_vlan_id").(bool)
+
+	switch {
+	case mode.needsPhyDev && d.Get("phydev").(string) == "":
+		return fmt.Errorf("Vnets with vn_mad %s need a phydev", mad)
+	case mode.needsVlan && !vlan:
+		return fmt.Errorf("Vnets with vn_mad %s need a vlan_id or automatic_vlan_id", mad)
+	case !mode.allowsVlan && vlan:
+		return fmt.Errorf("Vnets with vn_mad %s can't have a vlan_id or automatic_vlan_id", mad)
+	}
+
+	return nil
+}
+
+// findVnetByName looks for a single vnet with the given name that is owned by the user.
+// It returns nil if there is no such vnet and fails if the name is ambiguous.
+func findVnetByName(client *Client, name string) (*UserVnet, error) {
+	var vns *UserVnets
+	var vn *UserVnet
+
+	resp, err := client.Call("one.vnpool.info", -3, -1, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = xml.Unmarshal([]byte(resp), &vns); err != nil {
+		return nil, err
+	}
+
+	for _, t := range vns.UserVnet {
+		if t.Name != name || t.Uname != client.Username {
+			continue
+		}
+		if vn != nil {
+			return nil, fmt.Errorf("Found more than one vnet with name %s for user %s (IDs %d and %d)", name, client.Username, vn.Id, t.Id)
+		}
+		vn = t
+	}
+
+	return vn, nil
+}
+
 func resourceVnetExists(d *schema.ResourceData, meta interface{}) (bool, error) {
 	err := resourceVnetRead(d, meta)
 	if err != nil || d.Id() == "" {
@@ -240,17 +1234,32 @@ func resourceVnetExists(d *schema.ResourceData, meta interface{}) (bool, error)
 	return true, nil
 }
 
-func resourceVnetUpdate(d *schema.ResourceData, meta interface{}) error {
+func resourceVnetUpdate(d *schema.ResourceData, meta interface{}) (err error) {
 	client := meta.(*Client)
 
-	if d.HasChange("description") {
-		_, err := client.Call(
-			"one.vn.update",
-			intId(d.Id()),
-			d.Get("description").(string),
-			0, // replace the whole vnet instead of merging it with the existing one
-		)
+	if err = unlockForUpdate(d, client, "one.vn"); err != nil {
+		return err
+	}
+	defer relockAfterUpdate(d, client, "one.vn", &err)
+
+	if d.HasChange("description") || d.HasChange("bridge") || vnetAttributesChanged(d) {
+		if err := validateVnetMode(d); err != nil {
+			return err
+		}
+
+		tmpl, err := vnetTemplate(d)
+		if err != nil {
+			return err
+		}
+
+		old, err := renderVnetTemplate(oldValues(d))
 		if err != nil {
+			// the previous description may predate its validation, in which case no key is removed
+			log.Printf("[WARN] Could not parse the previous template of Vnet %s: %s\n", d.Id(), err)
+			old = template.New()
+		}
+
+		if err = updateTemplate(client, "vn", intId(d.Id()), old, tmpl); err != nil {
 			return err
 		}
 	}
@@ -267,16 +1276,28 @@ func resourceVnetUpdate(d *schema.ResourceData, meta interface{}) error {
 		log.Printf("[INFO] Successfully updated name for Vnet %s\n", resp)
 	}
 
+	if d.HasChange("address_range") {
+		old, new := d.GetChange("address_range")
+		ars, err := updateAddressRanges(client, intId(d.Id()), old.([]interface{}), new.([]interface{}))
+		if err != nil {
+			return err
+		}
+		if err = d.Set("address_range", ars); err != nil {
+			return err
+		}
+	}
+
 	if d.HasChange("ip_size") {
-		var address_range_string = `AR = [
-		AR_ID = 0,
-		TYPE = IP4,
-		IP = %s,
-		SIZE = %d ]`
+		// ip_start and ip_size describe the first address range, read back from OpenNebula
+		ars := d.Get("address_range").([]interface{})
+		if len(ars) == 0 {
+			return fmt.Errorf("Vnet %s has no address range to resize", d.Id())
+		}
+
 		resp, a_err := client.Call(
 			"one.vn.update_ar",
 			intId(d.Id()),
-			fmt.Sprintf(address_range_string, d.Get("ip_start").(string), d.Get("ip_size").(int)),
+			template.NewVector("AR").Add("AR_ID", ars[0].(map[string]interface{})["ar_id"].(int)).Add("SIZE", d.Get("ip_size").(int)).String(),
 		)
 
 		if a_err != nil {
@@ -289,6 +1310,37 @@ func resourceVnetUpdate(d *schema.ResourceData, meta interface{}) error {
 		log.Printf("[WARNING] Changing the IP address of the Vnet address range is currently not supported")
 	}
 
+	if d.HasChange("reservation_size") {
+		old, new := d.GetChange("reservation_size")
+		if err := changeReservation(d, client, "one.vn.release", old.(int)); err != nil {
+			return err
+		}
+		if err := changeReservation(d, client, "one.vn.hold", new.(int)); err != nil {
+			return err
+		}
+	}
+
+	if d.HasChange("hold_ips") {
+		old, new := d.GetChange("hold_ips")
+		for _, ip := range old.(*schema.Set).Difference(new.(*schema.Set)).List() {
+			if err := changeLease(client, intId(d.Id()), "one.vn.release", ip.(string), -1); err != nil {
+				return err
+			}
+		}
+		for _, ip := range new.(*schema.Set).Difference(old.(*schema.Set)).List() {
+			if err := changeLease(client, intId(d.Id()), "one.vn.hold", ip.(string), -1); err != nil {
+				return err
+			}
+		}
+	}
+
+	if d.HasChange("cluster_ids") {
+		old, new := d.GetChange("cluster_ids")
+		if err := changeClusters(client, "vnet", intId(d.Id()), old.(*schema.Set).List(), new.(*schema.Set).List()); err != nil {
+			return err
+		}
+	}
+
 	if d.HasChange("permissions") {
 		resp, err := changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.vn.chmod")
 		if err != nil {
@@ -306,27 +1358,19 @@ func resourceVnetDelete(d *schema.ResourceData, meta interface{}) error {
 		return err
 	}
 
+	if err = checkUnlocked("Vnet", d.Id(), d.Get("lock").(string)); err != nil {
+		return err
+	}
+
 	client := meta.(*Client)
-	if d.Get("reservation_size").(int) > 0 {
-		// add address range and reservations
-		ip := net.ParseIP(d.Get("ip_start").(string))
-		ip = ip.To4()
-
-		for i := 0; i < d.Get("reservation_size").(int); i++ {
-			var address_reservation_string = `LEASES=[IP=%s]`
-			_, r_err := client.Call(
-				"one.vn.release",
-				intId(d.Id()),
-				fmt.Sprintf(address_reservation_string, ip),
-			)
-
-			if r_err != nil {
-				return r_err
-			}
+	if err = changeReservation(d, client, "one.vn.release", d.Get("reservation_size").(int)); err != nil {
+		return err
+	}
 
-			ip[3]++
+	for _, ip := range d.Get("hold_ips").(*schema.Set).List() {
+		if err = changeLease(client, intId(d.Id()), "one.vn.release", ip.(string), -1); err != nil {
+			return err
 		}
-		log.Printf("[INFO] Successfully released reservered IP addresses.")
 	}
 
 	resp, err := client.Call("one.vn.delete", intId(d.Id()), false)
@@ -0,0 +1,157 @@
+package opennebula
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/hashicorp/terraform/helper/schema"
+	"log"
+	"strconv"
+	"strings"
+)
+
+func resourceVnetLeaseHold() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceVnetLeaseHoldCreate,
+		Read:   resourceVnetLeaseHoldRead,
+		Exists: resourceVnetLeaseHoldExists,
+		Delete: resourceVnetLeaseHoldDelete,
+		Importer: &schema.ResourceImporter{
+			State: resourceVnetLeaseHoldImport,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"vnet_id": {
+				Type:        schema.TypeInt,
+				Required:    true,
+				ForceNew:    true,
+				Description: "ID of the vnet the address belongs to",
+			},
+			"ip": {
+				Type:         schema.TypeString,
+				Required:     true,
+				ForceNew:     true,
+				Description:  "IPv4 or IPv6 address to put on hold",
+				ValidateFunc: validateIP,
+			},
+			"ar_id": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				Description: "ID of the address range of the address. Needed if the address is in more than one range",
+			},
+			"mac": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "MAC address of the lease",
+			},
+		},
+	}
+}
+
+func resourceVnetLeaseHoldCreate(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*Client)
+
+	// the first address range has ID 0, which GetOk would take for unset
+	arId := -1
+	if v, ok := d.GetOkExists("ar_id"); ok {
+		arId = v.(int)
+	}
+
+	ip, err := parseIP(d.Get("ip").(string))
+	if err != nil {
+		return err
+	}
+
+	if err = changeLease(client, d.Get("vnet_id").(int), "one.vn.hold", ip.String(), arId); err != nil {
+		return err
+	}
+
+	d.SetId(fmt.Sprintf("%d:%s", d.Get("vnet_id").(int), ip))
+
+	return resourceVnetLeaseHoldRead(d, meta)
+}
+
+func resourceVnetLeaseHoldRead(d *schema.ResourceData, meta interface{}) error {
+	var vn *UserVnet
+
+	client := meta.(*Client)
+
+	resp, err := client.Call("one.vn.info", d.Get("vnet_id").(int), false)
+	if err != nil {
+		if isNotFound(err) {
+			log.Printf("Could not find vnet by ID %d", d.Get("vnet_id").(int))
+			d.SetId("")
+			return nil
+		}
+		return err
+	}
+
+	if err = xml.Unmarshal([]byte(resp), &vn); err != nil {
+		return err
+	}
+
+	ip, err := parseIP(d.Get("ip").(string))
+	if err != nil {
+		return err
+	}
+
+	for _, l := range vnetLeases(vn) {
+		if !l["held"].(bool) || (!sameIP(l["ip"].(string), ip.String()) && !sameIP(l["ip6"].(string), ip.String())) {
+			continue
+		}
+
+		d.Set("ar_id", l["ar_id"])
+		d.Set("mac", l["mac"])
+		return nil
+	}
+
+	log.Printf("Address %s of vnet %d is no longer on hold", ip, vn.Id)
+	d.SetId("")
+	return nil
+}
+
+// sameIP compares two addresses regardless of how they're written
+func sameIP(a, b string) bool {
+	ipA, errA := parseIP(a)
+	ipB, errB := parseIP(b)
+
+	return errA == nil && errB == nil && ipA.Equal(ipB)
+}
+
+func resourceVnetLeaseHoldExists(d *schema.ResourceData, meta interface{}) (bool, error) {
+	err := resourceVnetLeaseHoldRead(d, meta)
+	if err != nil || d.Id() == "" {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func resourceVnetLeaseHoldDelete(d *schema.ResourceData, meta interface{}) error {
+	err := resourceVnetLeaseHoldRead(d, meta)
+	if err != nil || d.Id() == "" {
+		return err
+	}
+
+	client := meta.(*Client)
+	return changeLease(client, d.Get("vnet_id").(int), "one.vn.release", d.Get("ip").(string), d.Get("ar_id").(int))
+}
+
+// resourceVnetLeaseHoldImport imports holds by an ID of the form <vnet ID>:<IP>
+func resourceVnetLeaseHoldImport(d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	parts := strings.SplitN(d.Id(), ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Expected the ID of a lease hold to be <vnet ID>:<IP>, got %s", d.Id())
+	}
+
+	vnetId, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid vnet ID %s: %s", parts[0], err)
+	}
+
+	d.Set("vnet_id", vnetId)
+	d.Set("ip", parts[1])
+
+	return []*schema.ResourceData{d}, nil
+}
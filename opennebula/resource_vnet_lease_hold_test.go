@@ -0,0 +1,69 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/terraform"
+	"strings"
+	"testing"
+)
+
+func TestAccVnetLeaseHold(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVnetLeaseHoldDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccVnetLeaseHoldConfig,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_vnet_lease_hold.test", "ip", "192.168.0.5"),
+					resource.TestCheckResourceAttr("opennebula_vnet_lease_hold.test", "ar_id", "0"),
+					resource.TestCheckResourceAttrSet("opennebula_vnet_lease_hold.test", "mac"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "hold_ips.#", "1"),
+				),
+			},
+		},
+	})
+}
+
+func testAccCheckVnetLeaseHoldDestroy(s *terraform.State) error {
+	client := testAccProvider.Meta().(*Client)
+
+	for _, rs := range s.RootModule().Resources {
+		if rs.Type != "opennebula_vnet_lease_hold" {
+			continue
+		}
+
+		parts := strings.SplitN(rs.Primary.ID, ":", 2)
+		resp, err := client.Call("one.vn.info", intId(parts[0]), false)
+		if err == nil && strings.Contains(resp, parts[1]) {
+			return fmt.Errorf("Expected %s to have been released", rs.Primary.ID)
+		}
+	}
+
+	return nil
+}
+
+var testAccVnetLeaseHoldConfig = `
+resource "opennebula_vnet" "test" {
+  name = "test-vnet-holds"
+  description = <<EOF
+  VN_MAD="dummy"
+  EOF
+  bridge = "br-test"
+  permissions = "642"
+  hold_ips = ["192.168.0.2"]
+
+  address_range {
+    type = "IP4"
+    ip = "192.168.0.1"
+    size = 10
+  }
+}
+
+resource "opennebula_vnet_lease_hold" "test" {
+  vnet_id = "${opennebula_vnet.test.id}"
+  ip = "192.168.0.5"
+}
+`
@@ -0,0 +1,295 @@
+package opennebula
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/runtastic/terraform-provider-opennebula/opennebula/template"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+)
+
+func resourceVnetReservation() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceVnetReservationCreate,
+		Read:   resourceVnetReservationRead,
+		Exists: resourceVnetReservationExists,
+		Update: resourceVnetReservationUpdate,
+		Delete: resourceVnetReservationDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Name of the vnet the reservation creates",
+			},
+			"parent_vnet_id": {
+				Type:        schema.TypeInt,
+				Required:    true,
+				ForceNew:    true,
+				Description: "ID of the vnet to carve the reservation out of",
+			},
+			"size": {
+				Type:        schema.TypeInt,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Number of addresses to reserve",
+			},
+			"ar_id": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				Description: "ID of the address range of the parent vnet to reserve the addresses from. If not set, it is the one that holds ip, or one OpenNebula picks",
+			},
+			"ip": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				Computed:         true,
+				ForceNew:         true,
+				Description:      "First IP address to reserve. OpenNebula picks the first free block if not set",
+				ValidateFunc:     validateIP,
+				DiffSuppressFunc: suppressEquivalentIPs,
+			},
+			"mac": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "First MAC address of the reservation",
+			},
+			"permissions": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Permissions for the reservation (in Unix format, owner-group-other, use-manage-admin)",
+				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+					value := v.(string)
+
+					if len(value) != 3 {
+						errors = append(errors, fmt.Errorf("%q has specify 3 permission sets: owner-group-other", k))
+					}
+
+					all := true
+					for _, c := range strings.Split(value, "") {
+						if c < "0" || c > "7" {
+							all = false
+						}
+					}
+					if !all {
+						errors = append(errors, fmt.Errorf("Each character in %q should specify a Unix-like permission set with a number from 0 to 7", k))
+					}
+
+					return
+				},
+			},
+
+			"uid": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Computed:    true,
+				Description: "ID of the user that will own the reservation",
+			},
+			"gid": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Computed:    true,
+				Description: "ID of the group that will own the reservation",
+			},
+			"uname": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the user that will own the reservation",
+			},
+			"gname": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the group that will own the reservation",
+			},
+		},
+	}
+}
+
+func resourceVnetReservationCreate(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*Client)
+
+	tmpl := template.New().Add("NAME", d.Get("name").(string)).Add("SIZE", d.Get("size").(int))
+	arId, hasArId := d.GetOkExists("ar_id")
+	if ip, ok := d.GetOk("ip"); ok {
+		parsed, err := parseIP(ip.(string))
+		if err != nil {
+			return err
+		}
+
+		// OpenNebula only reserves given addresses from a given address range
+		if !hasArId {
+			if arId, err = reservationArId(client, d.Get("parent_vnet_id").(int), parsed, d.Get("size").(int)); err != nil {
+				return err
+			}
+			hasArId = true
+		}
+		tmpl.Add(ipLeaseAttribute(parsed), parsed)
+	}
+	if hasArId {
+		tmpl.Add("AR_ID", arId.(int))
+	}
+
+	resp, err := client.Call("one.vn.reserve", d.Get("parent_vnet_id").(int), tmpl.String())
+	if err != nil {
+		return err
+	}
+
+	d.SetId(resp)
+
+	if _, err = changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.vn.chmod"); err != nil {
+		return err
+	}
+
+	if err = changeOwnership(d, client, "one.vn.chown"); err != nil {
+		return err
+	}
+
+	return resourceVnetReservationRead(d, meta)
+}
+
+// reservationArId looks for the address range of the parent vnet that holds the size addresses from ip
+func reservationArId(client *Client, parentId int, ip net.IP, size int) (int, error) {
+	var vn *UserVnet
+
+	resp, err := client.Call("one.vn.info", parentId, false)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = xml.Unmarshal([]byte(resp), &vn); err != nil {
+		return 0, err
+	}
+
+	for _, ar := range vn.AddressRanges {
+		for _, first := range []string{ar.Ip, ar.Ip6} {
+			if first != "" && ipRangeContains(net.ParseIP(first), ar.Size, ip, size) {
+				return ar.Id, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("No address range of vnet %d holds the %d addresses from %s, set ar_id", parentId, size, ip)
+}
+
+func resourceVnetReservationRead(d *schema.ResourceData, meta interface{}) error {
+	var vn *UserVnet
+
+	client := meta.(*Client)
+
+	resp, err := client.Call("one.vn.info", intId(d.Id()), false)
+	if err != nil {
+		if isNotFound(err) {
+			log.Printf("Could not find vnet reservation by ID %s", d.Id())
+			d.SetId("")
+			return nil
+		}
+		return err
+	}
+
+	if err = xml.Unmarshal([]byte(resp), &vn); err != nil {
+		return err
+	}
+
+	parentId, err := strconv.Atoi(vn.ParentId)
+	if err != nil {
+		return fmt.Errorf("Vnet %s is not a reservation", d.Id())
+	}
+
+	d.SetId(strconv.Itoa(vn.Id))
+	d.Set("name", vn.Name)
+	d.Set("parent_vnet_id", parentId)
+	d.Set("uid", vn.Uid)
+	d.Set("gid", vn.Gid)
+	d.Set("uname", vn.Uname)
+	d.Set("gname", vn.Gname)
+	d.Set("permissions", permissionString(vn.Permissions))
+
+	size := 0
+	for _, ar := range vn.AddressRanges {
+		size += ar.Size
+	}
+	d.Set("size", size)
+
+	if len(vn.AddressRanges) > 0 {
+		first := vn.AddressRanges[0]
+		ip := first.Ip
+		if ip == "" {
+			ip = first.Ip6
+		}
+		d.Set("ip", ip)
+		d.Set("mac", first.Mac)
+
+		// the range of the parent vnet the addresses were carved from
+		if arId, err := strconv.Atoi(first.ParentArId); err == nil {
+			d.Set("ar_id", arId)
+		}
+	}
+
+	return nil
+}
+
+func resourceVnetReservationExists(d *schema.ResourceData, meta interface{}) (bool, error) {
+	err := resourceVnetReservationRead(d, meta)
+	if err != nil || d.Id() == "" {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func resourceVnetReservationUpdate(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*Client)
+
+	if d.HasChange("name") {
+		resp, err := client.Call(
+			"one.vn.rename",
+			intId(d.Id()),
+			d.Get("name").(string),
+		)
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated name for vnet reservation %s\n", resp)
+	}
+
+	if d.HasChange("permissions") {
+		resp, err := changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.vn.chmod")
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated vnet reservation %s\n", resp)
+	}
+
+	if d.HasChange("uid") || d.HasChange("gid") {
+		if err := changeOwnership(d, client, "one.vn.chown"); err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated owner of vnet reservation %s\n", d.Id())
+	}
+
+	return nil
+}
+
+func resourceVnetReservationDelete(d *schema.ResourceData, meta interface{}) error {
+	err := resourceVnetReservationRead(d, meta)
+	if err != nil || d.Id() == "" {
+		return err
+	}
+
+	// deleting the reservation hands its addresses back to the parent vnet
+	client := meta.(*Client)
+	resp, err := client.Call("one.vn.delete", intId(d.Id()), false)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Successfully deleted vnet reservation %s\n", resp)
+	return nil
+}
@@ -0,0 +1,128 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/terraform"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestAccVnetReservation(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVnetReservationDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccVnetReservationConfigBasic,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_vnet_reservation.test", "name", "test-reservation"),
+					resource.TestCheckResourceAttr("opennebula_vnet_reservation.test", "size", "5"),
+					resource.TestCheckResourceAttr("opennebula_vnet_reservation.test", "ip", "192.168.0.250"),
+					resource.TestCheckResourceAttr("opennebula_vnet_reservation.test", "permissions", "640"),
+					resource.TestCheckResourceAttrSet("opennebula_vnet_reservation.test", "mac"),
+					resource.TestCheckResourceAttrSet("opennebula_vnet_reservation.test", "uname"),
+				),
+			},
+			{
+				Config: testAccVnetReservationConfigUpdate,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_vnet_reservation.test", "name", "test-reservation-renamed"),
+					resource.TestCheckResourceAttr("opennebula_vnet_reservation.test", "permissions", "600"),
+				),
+			},
+		},
+	})
+}
+
+func TestReservationArId(t *testing.T) {
+	client, standIn := newTestClient(t, map[string]func([]string) (interface{}, error){
+		"one.vn.info": func(params []string) (interface{}, error) {
+			return `<VNET><ID>3</ID><AR_POOL>
+<AR><AR_ID>0</AR_ID><TYPE>IP4</TYPE><IP>192.168.0.1</IP><SIZE>100</SIZE></AR>
+<AR><AR_ID>1</AR_ID><TYPE>IP4</TYPE><IP>192.168.1.1</IP><SIZE>100</SIZE></AR>
+<AR><AR_ID>2</AR_ID><TYPE>IP6_STATIC</TYPE><IP6>2001:db8::1</IP6><SIZE>100</SIZE></AR>
+</AR_POOL></VNET>`, nil
+		},
+	})
+	defer standIn.Close()
+
+	for ip, expected := range map[string]int{"192.168.0.1": 0, "192.168.1.50": 1, "2001:db8::10": 2} {
+		if arId, err := reservationArId(client, 3, net.ParseIP(ip), 5); err != nil || arId != expected {
+			t.Errorf("Expected %s to be in address range %d, got %d (err: %v)", ip, expected, arId, err)
+		}
+	}
+
+	// the reservation has to fit in a single range
+	if arId, err := reservationArId(client, 3, net.ParseIP("192.168.0.98"), 5); err == nil || !strings.Contains(err.Error(), "set ar_id") {
+		t.Errorf("Expected no address range to hold the reservation, got %d (err: %v)", arId, err)
+	}
+	if arId, err := reservationArId(client, 3, net.ParseIP("10.0.0.1"), 5); err == nil {
+		t.Errorf("Expected no address range to hold 10.0.0.1, got %d", arId)
+	}
+}
+
+func testAccCheckVnetReservationDestroy(s *terraform.State) error {
+	client := testAccProvider.Meta().(*Client)
+
+	for _, rs := range s.RootModule().Resources {
+		_, err := client.Call("one.vn.info", intId(rs.Primary.ID), false)
+		if err == nil {
+			return fmt.Errorf("Expected vnet %s to have been destroyed", rs.Primary.ID)
+		}
+	}
+
+	return nil
+}
+
+var testAccVnetReservationConfigBasic = `
+resource "opennebula_vnet" "parent" {
+  name = "test-reservation-parent"
+  description = <<EOF
+  VN_MAD="dummy"
+  EOF
+  bridge = "br-test"
+  permissions = "642"
+
+  address_range {
+    type = "IP4"
+    ip = "192.168.0.250"
+    size = 20
+  }
+}
+
+resource "opennebula_vnet_reservation" "test" {
+  name = "test-reservation"
+  parent_vnet_id = "${opennebula_vnet.parent.id}"
+  size = 5
+  ip = "192.168.0.250"
+  permissions = "640"
+}
+`
+
+var testAccVnetReservationConfigUpdate = `
+resource "opennebula_vnet" "parent" {
+  name = "test-reservation-parent"
+  description = <<EOF
+  VN_MAD="dummy"
+  EOF
+  bridge = "br-test"
+  permissions = "642"
+
+  address_range {
+    type = "IP4"
+    ip = "192.168.0.250"
+    size = 20
+  }
+}
+
+resource "opennebula_vnet_reservation" "test" {
+  name = "test-reservation-renamed"
+  parent_vnet_id = "${opennebula_vnet.parent.id}"
+  size = 5
+  ip = "192.168.0.250"
+  permissions = "600"
+}
+`
@@ -0,0 +1,342 @@
+package opennebula
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
+	"github.com/runtastic/terraform-provider-opennebula/opennebula/template"
+	"log"
+	"strconv"
+	"strings"
+)
+
+type VnTemplate struct {
+	Name        string              `xml:"NAME"`
+	Id          int                 `xml:"ID"`
+	Uid         int                 `xml:"UID"`
+	Gid         int                 `xml:"GID"`
+	Uname       string              `xml:"UNAME"`
+	Gname       string              `xml:"GNAME"`
+	RegTime     int                 `xml:"REGTIME"`
+	Permissions *Permissions        `xml:"PERMISSIONS"`
+	Lock        *Lock               `xml:"LOCK"`
+	Template    *VnTemplateContents `xml:"TEMPLATE"`
+}
+
+type VnTemplateContents struct {
+	VnetTemplate
+	Bridge        string              `xml:"BRIDGE"`
+	VnMad         string              `xml:"VN_MAD"`
+	PhyDev        string              `xml:"PHYDEV"`
+	VlanId        string              `xml:"VLAN_ID"`
+	AutoVlanId    string              `xml:"AUTOMATIC_VLAN_ID"`
+	ClusterIds    string              `xml:"CLUSTER_IDS"`
+	AddressRanges []*VnetAddressRange `xml:"AR"`
+}
+
+func resourceVnTemplate() *schema.Resource {
+	resource := &schema.Resource{
+		Create: resourceVnTemplateCreate,
+		Read:   resourceVnTemplateRead,
+		Exists: resourceVnTemplateExists,
+		Update: resourceVnTemplateUpdate,
+		Delete: resourceVnTemplateDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Name of the vnet template",
+			},
+			"description": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				Default:          "",
+				Description:      "Additional attributes of the vnet template, in OpenNebula's XML or String format",
+				ValidateFunc:     validateTemplate,
+				DiffSuppressFunc: suppressEquivalentTemplates,
+			},
+			"permissions": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Permissions for the vnet template (in Unix format, owner-group-other, use-manage-admin)",
+				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+					value := v.(string)
+
+					if len(value) != 3 {
+						errors = append(errors, fmt.Errorf("%q has specify 3 permission sets: owner-group-other", k))
+					}
+
+					all := true
+					for _, c := range strings.Split(value, "") {
+						if c < "0" || c > "7" {
+							all = false
+						}
+					}
+					if !all {
+						errors = append(errors, fmt.Errorf("Each character in %q should specify a Unix-like permission set with a number from 0 to 7", k))
+					}
+
+					return
+				},
+			},
+
+			"uid": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the user that will own the vnet template",
+			},
+			"gid": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the group that will own the vnet template",
+			},
+			"uname": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the user that will own the vnet template",
+			},
+			"gname": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the group that will own the vnet template",
+			},
+			"reg_time": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Registration time",
+			},
+			"address_range": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Address ranges of the vnets instantiated from the template",
+				Elem: &schema.Resource{
+					Schema: addressRangeSchema(),
+				},
+			},
+			"lock": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "Lock level of the vnet template: USE, MANAGE, ADMIN or ALL. Locked vnet templates can't be destroyed",
+				ValidateFunc: validation.StringInSlice([]string{"USE", "MANAGE", "ADMIN", "ALL"}, false),
+			},
+		},
+	}
+
+	for k, v := range vnetNetworkSchema() {
+		resource.Schema[k] = v
+	}
+	// unlike the one of a vnet, the driver of a template only applies to the vnets instantiated from then on
+	resource.Schema["vn_mad"].ForceNew = false
+
+	return resource
+}
+
+// vnTemplateTemplate renders the vnet template, address ranges included
+func vnTemplateTemplate(d *schema.ResourceData) (*template.Template, error) {
+	if err := validateVnetMode(d); err != nil {
+		return nil, err
+	}
+
+	tmpl, err := vnetTemplate(d)
+	if err != nil {
+		return nil, err
+	}
+
+	if clusters := d.Get("cluster_ids").(*schema.Set).List(); len(clusters) > 0 {
+		tmpl.Add("CLUSTER_IDS", joinInts(clusters))
+	}
+
+	for _, ar := range d.Get("address_range").([]interface{}) {
+		rendered, err := addressRangeTemplate(ar.(map[string]interface{}), -1)
+		if err != nil {
+			return nil, err
+		}
+		tmpl.Append(rendered)
+	}
+
+	return tmpl, nil
+}
+
+func resourceVnTemplateCreate(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*Client)
+
+	tmpl, err := vnTemplateTemplate(d)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Call(
+		"one.vntemplate.allocate",
+		template.New().Add("NAME", d.Get("name").(string)).Append(tmpl.Attributes...).String(),
+	)
+	if err != nil {
+		return err
+	}
+
+	d.SetId(resp)
+
+	if _, err = changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.vntemplate.chmod"); err != nil {
+		return err
+	}
+
+	if err = changeLock(intId(d.Id()), "", d.Get("lock").(string), client, "one.vntemplate"); err != nil {
+		return err
+	}
+
+	return resourceVnTemplateRead(d, meta)
+}
+
+func resourceVnTemplateRead(d *schema.ResourceData, meta interface{}) error {
+	var vnt *VnTemplate
+
+	client := meta.(*Client)
+
+	resp, err := client.Call("one.vntemplate.info", intId(d.Id()), false)
+	if err != nil {
+		if isNotFound(err) {
+			log.Printf("Could not find vnet template by ID %s", d.Id())
+			d.SetId("")
+			return nil
+		}
+		return err
+	}
+
+	if err = xml.Unmarshal([]byte(resp), &vnt); err != nil {
+		return err
+	}
+
+	d.SetId(strconv.Itoa(vnt.Id))
+	d.Set("name", vnt.Name)
+	d.Set("uid", vnt.Uid)
+	d.Set("gid", vnt.Gid)
+	d.Set("uname", vnt.Uname)
+	d.Set("gname", vnt.Gname)
+	d.Set("reg_time", vnt.RegTime)
+	d.Set("permissions", permissionString(vnt.Permissions))
+	d.Set("lock", lockLevelName(vnt.Lock))
+
+	t := vnt.Template
+	if t == nil {
+		return nil
+	}
+
+	mtu, _ := strconv.Atoi(t.Mtu)
+	guestMtu, _ := strconv.Atoi(t.GuestMtu)
+	d.Set("bridge", t.Bridge)
+	d.Set("vn_mad", t.VnMad)
+	d.Set("phydev", t.PhyDev)
+	d.Set("vlan_id", t.VlanId)
+	d.Set("automatic_vlan_id", strings.ToUpper(t.AutoVlanId) == "YES")
+	d.Set("mtu", mtu)
+	d.Set("guest_mtu", guestMtu)
+	d.Set("gateway", t.Gateway)
+	d.Set("dns", t.Dns)
+	d.Set("network_mask", t.NetworkMask)
+	if err = d.Set("security_groups", splitInts(t.SecurityGroups)); err != nil {
+		return err
+	}
+	if err = d.Set("cluster_ids", splitInts(t.ClusterIds)); err != nil {
+		return err
+	}
+
+	configured := d.Get("address_range").([]interface{})
+	ars := make([]map[string]interface{}, 0, len(t.AddressRanges))
+	for i, ar := range t.AddressRanges {
+		var keys map[string]interface{}
+		if i < len(configured) && configured[i] != nil {
+			keys = configured[i].(map[string]interface{})["attributes"].(map[string]interface{})
+		}
+		attrs := addressRangeAttributes(ar, keys)
+		// address ranges of templates don't have an ID until they're instantiated
+		delete(attrs, "ar_id")
+		ars = append(ars, attrs)
+	}
+	if err = d.Set("address_range", ars); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func resourceVnTemplateExists(d *schema.ResourceData, meta interface{}) (bool, error) {
+	err := resourceVnTemplateRead(d, meta)
+	if err != nil || d.Id() == "" {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func resourceVnTemplateUpdate(d *schema.ResourceData, meta interface{}) (err error) {
+	client := meta.(*Client)
+
+	if err = unlockForUpdate(d, client, "one.vntemplate"); err != nil {
+		return err
+	}
+	defer relockAfterUpdate(d, client, "one.vntemplate", &err)
+
+	if d.HasChange("name") {
+		resp, err := client.Call(
+			"one.vntemplate.rename",
+			intId(d.Id()),
+			d.Get("name").(string),
+		)
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated vnet template name to %s\n", resp)
+	}
+
+	if d.HasChange("description") || d.HasChange("bridge") || d.HasChange("vn_mad") || d.HasChange("cluster_ids") ||
+		d.HasChange("address_range") || vnetAttributesChanged(d) {
+		tmpl, err := vnTemplateTemplate(d)
+		if err != nil {
+			return err
+		}
+
+		_, err = client.Call(
+			"one.vntemplate.update",
+			intId(d.Id()),
+			tmpl.String(),
+			0, // replace the whole vnet template instead of merging it with the existing one
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if d.HasChange("permissions") {
+		resp, err := changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.vntemplate.chmod")
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully updated vnet template %s\n", resp)
+	}
+
+	return nil
+}
+
+func resourceVnTemplateDelete(d *schema.ResourceData, meta interface{}) error {
+	err := resourceVnTemplateRead(d, meta)
+	if err != nil || d.Id() == "" {
+		return err
+	}
+
+	if err = checkUnlocked("Vnet template", d.Id(), d.Get("lock").(string)); err != nil {
+		return err
+	}
+
+	client := meta.(*Client)
+	resp, err := client.Call("one.vntemplate.delete", intId(d.Id()))
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Successfully deleted vnet template %s\n", resp)
+	return nil
+}
@@ -0,0 +1,128 @@
+package opennebula
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/terraform"
+	"testing"
+)
+
+func TestAccVnTemplate(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVnTemplateDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccVnTemplateConfigBasic,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_vnet_template.test", "name", "test-vntemplate"),
+					resource.TestCheckResourceAttr("opennebula_vnet_template.test", "vn_mad", "802.1Q"),
+					resource.TestCheckResourceAttr("opennebula_vnet_template.test", "automatic_vlan_id", "true"),
+					resource.TestCheckResourceAttr("opennebula_vnet_template.test", "address_range.#", "1"),
+					resource.TestCheckResourceAttr("opennebula_vnet_template.test", "address_range.0.ip", "10.0.0.1"),
+					resource.TestCheckResourceAttrSet("opennebula_vnet_template.test", "reg_time"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "vn_mad", "802.1Q"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "address_range.#", "1"),
+					resource.TestCheckResourceAttrSet("opennebula_vnet.test", "vlan_id"),
+				),
+			},
+			{
+				Config: testAccVnTemplateConfigUpdate,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_vnet_template.test", "name", "test-vntemplate-renamed"),
+					resource.TestCheckResourceAttr("opennebula_vnet_template.test", "gateway", "10.0.0.254"),
+					resource.TestCheckResourceAttr("opennebula_vnet_template.test", "address_range.0.size", "50"),
+				),
+			},
+		},
+	})
+}
+
+func TestVnTemplateContents(t *testing.T) {
+	var vnt *VnTemplate
+	err := xml.Unmarshal([]byte(`<VNTEMPLATE><ID>2</ID><NAME>private</NAME><TEMPLATE>
+<BRIDGE>br0</BRIDGE><VN_MAD>vxlan</VN_MAD><PHYDEV>eth1</PHYDEV><AUTOMATIC_VLAN_ID>YES</AUTOMATIC_VLAN_ID>
+<GATEWAY>10.0.0.254</GATEWAY><SECURITY_GROUPS>0,100</SECURITY_GROUPS><CLUSTER_IDS>100</CLUSTER_IDS>
+<AR><TYPE>IP4</TYPE><IP>10.0.0.1</IP><SIZE>20</SIZE></AR>
+<AR><TYPE>ETHER</TYPE><SIZE>5</SIZE></AR>
+</TEMPLATE></VNTEMPLATE>`), &vnt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := vnt.Template
+	if c.VnMad != "vxlan" || c.PhyDev != "eth1" || c.AutoVlanId != "YES" || c.ClusterIds != "100" {
+		t.Errorf("Expected the network attributes of the template to be read, got %+v", c)
+	}
+	if c.Gateway != "10.0.0.254" || c.SecurityGroups != "0,100" {
+		t.Errorf("Expected the attributes shared with vnets to be read, got %+v", c.VnetTemplate)
+	}
+	if len(c.AddressRanges) != 2 || c.AddressRanges[0].Ip != "10.0.0.1" || c.AddressRanges[1].Size != 5 {
+		t.Errorf("Expected 2 address ranges, got %v", c.AddressRanges)
+	}
+}
+
+func testAccCheckVnTemplateDestroy(s *terraform.State) error {
+	client := testAccProvider.Meta().(*Client)
+
+	for _, rs := range s.RootModule().Resources {
+		method := "one.vntemplate.info"
+		if rs.Type == "opennebula_vnet" {
+			method = "one.vn.info"
+		}
+		if _, err := client.Call(method, intId(rs.Primary.ID), false); err == nil {
+			return fmt.Errorf("Expected %s %s to have been destroyed", rs.Type, rs.Primary.ID)
+		}
+	}
+
+	return nil
+}
+
+var testAccVnTemplateConfigBasic = `
+resource "opennebula_vnet_template" "test" {
+  name = "test-vntemplate"
+  permissions = "644"
+  bridge = "br-tenant"
+  vn_mad = "802.1Q"
+  phydev = "eth0"
+  automatic_vlan_id = true
+
+  address_range {
+    type = "IP4"
+    ip = "10.0.0.1"
+    size = 20
+  }
+}
+
+resource "opennebula_vnet" "test" {
+  name = "test-vnet-from-template"
+  permissions = "600"
+  template_id = "${opennebula_vnet_template.test.id}"
+}
+`
+
+var testAccVnTemplateConfigUpdate = `
+resource "opennebula_vnet_template" "test" {
+  name = "test-vntemplate-renamed"
+  permissions = "644"
+  bridge = "br-tenant"
+  vn_mad = "802.1Q"
+  phydev = "eth0"
+  automatic_vlan_id = true
+  gateway = "10.0.0.254"
+
+  address_range {
+    type = "IP4"
+    ip = "10.0.0.1"
+    size = 50
+  }
+}
+
+resource "opennebula_vnet" "test" {
+  name = "test-vnet-from-template"
+  permissions = "600"
+  template_id = "${opennebula_vnet_template.test.id}"
+}
+`
@@ -147,3 +147,459 @@ resource "opennebula_vnet" "test" {
   permissions = "700"
 }
 `
+
+func TestAccVnetAddressRanges(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVnetDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccVnetConfigAddressRanges,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "address_range.#", "2"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "address_range.0.type", "IP4"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "address_range.0.size", "10"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "address_range.0.attributes.GATEWAY", "192.168.0.254"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "address_range.1.type", "IP6"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "address_range.1.global_prefix", "2001:db8::"),
+					resource.TestCheckResourceAttrSet("opennebula_vnet.test", "address_range.1.mac"),
+				),
+			},
+			{
+				Config: testAccVnetConfigAddressRangesUpdate,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "address_range.#", "2"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "address_range.0.size", "20"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "address_range.1.type", "ETHER"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "address_range.1.mac", "02:00:00:00:01:00"),
+				),
+			},
+			{
+				Config: testAccVnetConfigAddressRangesMiddle,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "address_range.#", "3"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "address_range.1.ip", "192.168.1.1"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "address_range.2.type", "ETHER"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "address_range.2.ar_id", "2"),
+				),
+			},
+			{
+				// removing the middle range again leaves the last one alone
+				Config: testAccVnetConfigAddressRangesUpdate,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "address_range.#", "2"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "address_range.1.type", "ETHER"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "address_range.1.ar_id", "2"),
+				),
+			},
+		},
+	})
+}
+
+func TestUpdateAddressRanges(t *testing.T) {
+	info := "<VNET><ID>3</ID><AR_POOL><AR><AR_ID>0</AR_ID><TYPE>IP4</TYPE><IP>10.0.0.1</IP><SIZE>20</SIZE></AR>" +
+		"<AR><AR_ID>3</AR_ID><TYPE>IP4_6</TYPE><IP>10.0.1.1</IP><MAC>02:00:0a:00:01:01</MAC><SIZE>5</SIZE></AR></AR_POOL></VNET>"
+
+	client, standIn := newTestClient(t, map[string]func([]string) (interface{}, error){
+		"one.vn.info":      func(params []string) (interface{}, error) { return info, nil },
+		"one.vn.rm_ar":     func(params []string) (interface{}, error) { return 3, nil },
+		"one.vn.add_ar":    func(params []string) (interface{}, error) { return 3, nil },
+		"one.vn.update_ar": func(params []string) (interface{}, error) { return 3, nil },
+	})
+	defer standIn.Close()
+
+	old := []interface{}{
+		testAddressRange(0, "IP4", "10.0.0.1", 10, map[string]interface{}{"GATEWAY": "10.0.0.254"}),
+		testAddressRange(1, "IP6", "", 5, map[string]interface{}{}),
+		testAddressRange(2, "ETHER", "", 3, map[string]interface{}{}),
+	}
+	new := []interface{}{
+		testAddressRange(0, "IP4", "10.0.0.1", 20, map[string]interface{}{"GATEWAY": "10.0.0.254"}),
+		testAddressRange(0, "IP4_6", "10.0.1.1", 5, map[string]interface{}{}),
+	}
+
+	ars, err := updateAddressRanges(client, 3, old, new)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []xmlRpcCall{
+		{Method: "one.vn.rm_ar", Params: []string{"3", "1"}},
+		{Method: "one.vn.rm_ar", Params: []string{"3", "2"}},
+		{Method: "one.vn.add_ar", Params: []string{"3", "AR = [\n  TYPE = \"IP4_6\",\n  IP = \"10.0.1.1\",\n  SIZE = \"5\" ]"}},
+		{Method: "one.vn.info", Params: []string{"3", "0"}},
+		{Method: "one.vn.update_ar", Params: []string{"3", "AR = [\n  AR_ID = \"0\",\n  SIZE = \"20\",\n  GATEWAY = \"10.0.0.254\" ]"}},
+	}
+	standIn.checkCalls(t, expected)
+
+	if arIds := addressRangeIds(ars); !reflect.DeepEqual(arIds, []int{0, 3}) {
+		t.Errorf("Expected the address ranges to have IDs [0 3], got %v", arIds)
+	}
+	if mac := ars[1].(map[string]interface{})["mac"]; mac != "02:00:0a:00:01:01" {
+		t.Errorf("Expected the added address range to have the MAC OpenNebula gave it, got %v", mac)
+	}
+}
+
+func TestUpdateAddressRangesInTheMiddle(t *testing.T) {
+	info := "<VNET><ID>3</ID><AR_POOL><AR><AR_ID>0</AR_ID><TYPE>IP4</TYPE><IP>10.0.0.1</IP><SIZE>10</SIZE></AR>" +
+		"<AR><AR_ID>2</AR_ID><TYPE>IP4</TYPE><IP>10.0.2.1</IP><SIZE>10</SIZE></AR>" +
+		"<AR><AR_ID>3</AR_ID><TYPE>IP4</TYPE><IP>10.0.5.1</IP><SIZE>10</SIZE></AR></AR_POOL></VNET>"
+
+	client, standIn := newTestClient(t, map[string]func([]string) (interface{}, error){
+		"one.vn.info":   func(params []string) (interface{}, error) { return info, nil },
+		"one.vn.rm_ar":  func(params []string) (interface{}, error) { return 3, nil },
+		"one.vn.add_ar": func(params []string) (interface{}, error) { return 3, nil },
+	})
+	defer standIn.Close()
+
+	old := []interface{}{
+		testAddressRange(0, "IP4", "10.0.0.1", 10, map[string]interface{}{}),
+		testAddressRange(1, "IP4", "10.0.1.1", 10, map[string]interface{}{}),
+		testAddressRange(2, "IP4", "10.0.2.1", 10, map[string]interface{}{}),
+	}
+	for i, ar := range old {
+		ar.(map[string]interface{})["mac"] = fmt.Sprintf("02:00:0a:00:0%d:01", i)
+	}
+
+	// the planned blocks carry over the ID and MAC of the block that was at their position before
+	planned := func(i int, ip string) map[string]interface{} {
+		ar := testAddressRange(i, "IP4", ip, 10, map[string]interface{}{})
+		ar["mac"] = old[i].(map[string]interface{})["mac"]
+		return ar
+	}
+
+	// replacing the middle range leaves the last one alone
+	replaced := []interface{}{planned(0, "10.0.0.1"), planned(1, "10.0.5.1"), planned(2, "10.0.2.1")}
+	ars, err := updateAddressRanges(client, 3, old, replaced)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []xmlRpcCall{
+		{Method: "one.vn.rm_ar", Params: []string{"3", "1"}},
+		{Method: "one.vn.add_ar", Params: []string{"3", "AR = [\n  TYPE = \"IP4\",\n  IP = \"10.0.5.1\",\n  MAC = \"02:00:0a:00:01:01\",\n  SIZE = \"10\" ]"}},
+		{Method: "one.vn.info", Params: []string{"3", "0"}},
+	}
+	standIn.checkCalls(t, expected)
+	if arIds := addressRangeIds(ars); !reflect.DeepEqual(arIds, []int{0, 3, 2}) {
+		t.Errorf("Expected the replaced address range to get a new ID in place, got %v", arIds)
+	}
+
+	// removing the middle range leaves the last one alone too
+	standIn.Calls = nil
+	ars, err = updateAddressRanges(client, 3, old, []interface{}{planned(0, "10.0.0.1"), planned(1, "10.0.2.1")})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected = []xmlRpcCall{
+		{Method: "one.vn.rm_ar", Params: []string{"3", "1"}},
+	}
+	standIn.checkCalls(t, expected)
+	if arIds := addressRangeIds(ars); !reflect.DeepEqual(arIds, []int{0, 2}) {
+		t.Errorf("Expected the last address range to keep its ID, got %v", arIds)
+	}
+	if mac := ars[1].(map[string]interface{})["mac"]; mac != "02:00:0a:00:02:01" {
+		t.Errorf("Expected the last address range to keep its MAC, got %v", mac)
+	}
+
+	// inserting a range in the middle doesn't reuse the MAC of the range that moves down
+	standIn.Calls = nil
+	last := testAddressRange(0, "IP4", "10.0.2.1", 10, map[string]interface{}{})
+	inserted := []interface{}{planned(0, "10.0.0.1"), planned(1, "10.0.5.1"), planned(2, "10.0.1.1"), last}
+	ars, err = updateAddressRanges(client, 3, old, inserted)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected = []xmlRpcCall{
+		{Method: "one.vn.add_ar", Params: []string{"3", "AR = [\n  TYPE = \"IP4\",\n  IP = \"10.0.5.1\",\n  SIZE = \"10\" ]"}},
+		{Method: "one.vn.info", Params: []string{"3", "0"}},
+	}
+	standIn.checkCalls(t, expected)
+	if arIds := addressRangeIds(ars); !reflect.DeepEqual(arIds, []int{0, 3, 1, 2}) {
+		t.Errorf("Expected the ranges after the inserted one to keep their IDs, got %v", arIds)
+	}
+}
+
+func TestVnetAddressRanges(t *testing.T) {
+	vn := &UserVnet{AddressRanges: []*VnetAddressRange{
+		{Id: 0, Type: "IP4", Ip: "10.0.0.1", Size: 10},
+		{Id: 2, Type: "IP4", Ip: "10.0.2.1", Size: 10},
+		{Id: 3, Type: "IP4", Ip: "10.0.5.1", Size: 10},
+		{Id: 4, Type: "ETHER", Size: 10},
+	}}
+	known := []interface{}{
+		testAddressRange(0, "IP4", "10.0.0.1", 10, map[string]interface{}{}),
+		testAddressRange(3, "IP4", "10.0.5.1", 10, map[string]interface{}{}),
+		testAddressRange(1, "IP4", "10.0.1.1", 10, map[string]interface{}{}),
+		testAddressRange(2, "IP4", "10.0.2.1", 10, map[string]interface{}{}),
+	}
+
+	ars := make([]interface{}, 0)
+	for _, ar := range vnetAddressRanges(vn, known) {
+		ars = append(ars, ar)
+	}
+	if arIds := addressRangeIds(ars); !reflect.DeepEqual(arIds, []int{0, 3, 2, 4}) {
+		t.Errorf("Expected the address ranges in the known order, then the unknown ones, got %v", arIds)
+	}
+}
+
+func addressRangeIds(ars []interface{}) []int {
+	arIds := make([]int, 0, len(ars))
+	for _, ar := range ars {
+		arIds = append(arIds, ar.(map[string]interface{})["ar_id"].(int))
+	}
+
+	return arIds
+}
+
+func TestAddressRangeTemplateValidation(t *testing.T) {
+	invalid := []map[string]interface{}{
+		testAddressRange(0, "IP4", "", 10, map[string]interface{}{}),
+		testAddressRange(0, "ETHER", "10.0.0.1", 10, map[string]interface{}{}),
+		testAddressRange(0, "IP6_STATIC", "", 10, map[string]interface{}{}),
+	}
+	invalid = append(invalid, testAddressRange(0, "IP4", "10.0.0.1", 10, map[string]interface{}{"GATEWAY = \"10.0.0.254\" ]\nNIC = [ FOO": "bar"}))
+	ip4Prefix := testAddressRange(0, "IP4", "10.0.0.1", 10, map[string]interface{}{})
+	ip4Prefix["global_prefix"] = "2001:db8::"
+	invalid = append(invalid, ip4Prefix)
+
+	for _, ar := range invalid {
+		if tmpl, err := addressRangeTemplate(ar, -1); err == nil {
+			t.Errorf("Expected %v to be invalid, got %s", ar, tmpl)
+		}
+	}
+
+	static := testAddressRange(0, "IP6_STATIC", "", 10, map[string]interface{}{})
+	static["ip6"] = "2001:db8::1"
+	static["prefix_length"] = 64
+	tmpl, err := addressRangeTemplate(static, -1)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if ip6, _ := tmpl.Get("IP6"); ip6 != "2001:db8::1" {
+		t.Errorf("Expected the IP6_STATIC range to have its IP6, got %s", tmpl)
+	}
+	if prefix, _ := tmpl.Get("PREFIX_LENGTH"); prefix != "64" {
+		t.Errorf("Expected the IP6_STATIC range to have its prefix length, got %s", tmpl)
+	}
+
+	ip6 := testAddressRange(0, "IP6", "", 10, map[string]interface{}{})
+	ip6["global_prefix"] = "2001:db8::"
+	tmpl, err = addressRangeTemplate(ip6, -1)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(tmpl.String(), `GLOBAL_PREFIX = "2001:db8::"`) {
+		t.Errorf("Expected the IP6 range to have its GLOBAL_PREFIX, got %s", tmpl)
+	}
+}
+
+func testAddressRange(id int, arType, ip string, size int, attributes map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"ar_id":         id,
+		"type":          arType,
+		"ip":            ip,
+		"ip6":           "",
+		"prefix_length": 0,
+		"mac":           "",
+		"size":          size,
+		"global_prefix": "",
+		"ula_prefix":    "",
+		"attributes":    attributes,
+	}
+}
+
+var testAccVnetConfigAddressRanges = `
+resource "opennebula_vnet" "test" {
+  name = "test-vnet-ars"
+  description = <<EOF
+  VN_MAD="dummy"
+  EOF
+  bridge = "br-test"
+  permissions = "642"
+
+  address_range {
+    type = "IP4"
+    ip = "192.168.0.1"
+    size = 10
+    attributes = {
+      GATEWAY = "192.168.0.254"
+    }
+  }
+
+  address_range {
+    type = "IP6"
+    global_prefix = "2001:db8::"
+    size = 5
+  }
+}
+`
+
+var testAccVnetConfigAddressRangesUpdate = `
+resource "opennebula_vnet" "test" {
+  name = "test-vnet-ars"
+  description = <<EOF
+  VN_MAD="dummy"
+  EOF
+  bridge = "br-test"
+  permissions = "642"
+
+  address_range {
+    type = "IP4"
+    ip = "192.168.0.1"
+    size = 20
+    attributes = {
+      GATEWAY = "192.168.0.254"
+    }
+  }
+
+  address_range {
+    type = "ETHER"
+    mac = "02:00:00:00:01:00"
+    size = 5
+  }
+}
+`
+
+var testAccVnetConfigAddressRangesMiddle = `
+resource "opennebula_vnet" "test" {
+  name = "test-vnet-ars"
+  description = <<EOF
+  VN_MAD="dummy"
+  EOF
+  bridge = "br-test"
+  permissions = "642"
+
+  address_range {
+    type = "IP4"
+    ip = "192.168.0.1"
+    size = 20
+    attributes = {
+      GATEWAY = "192.168.0.254"
+    }
+  }
+
+  address_range {
+    type = "IP4"
+    ip = "192.168.1.1"
+    size = 5
+  }
+
+  address_range {
+    type = "ETHER"
+    mac = "02:00:00:00:01:00"
+    size = 5
+  }
+}
+`
+
+func TestVnetLeases(t *testing.T) {
+	var vn *UserVnet
+	err := xml.Unmarshal([]byte(`<VNET><ID>3</ID><AR_POOL>
+<AR><AR_ID>0</AR_ID><TYPE>IP4</TYPE><IP>10.0.0.1</IP><SIZE>10</SIZE><GATEWAY>10.0.0.254</GATEWAY><LEASES>
+<LEASE><IP>10.0.0.1</IP><MAC>02:00:0a:00:00:01</MAC><VM>12</VM></LEASE>
+<LEASE><IP>10.0.0.2</IP><MAC>02:00:0a:00:00:02</MAC><VM>-1</VM></LEASE>
+<LEASE><IP>10.0.0.3</IP><MAC>02:00:0a:00:00:03</MAC><VNET>7</VNET></LEASE>
+</LEASES></AR>
+<AR><AR_ID>1</AR_ID><TYPE>IP6_STATIC</TYPE><IP6>2001:db8::1</IP6><SIZE>10</SIZE><LEASES>
+<LEASE><IP6>2001:0db8::0005</IP6><MAC>02:00:00:00:01:05</MAC><VM>-1</VM></LEASE>
+</LEASES></AR>
+</AR_POOL></VNET>`), &vn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leases := vnetLeases(vn)
+	if len(leases) != 4 {
+		t.Fatalf("Expected 4 leases, got %v", leases)
+	}
+	if leases[0]["vm_id"] != 12 || leases[0]["held"] != false || leases[0]["vnet_id"] != -1 {
+		t.Errorf("Expected the first lease to be used by VM 12, got %v", leases[0])
+	}
+	if leases[2]["vm_id"] != -1 || leases[2]["vnet_id"] != 7 || leases[2]["held"] != false {
+		t.Errorf("Expected the third lease to be used by reservation 7, got %v", leases[2])
+	}
+	if leases[3]["ar_id"] != 1 {
+		t.Errorf("Expected the last lease to be in address range 1, got %v", leases[3])
+	}
+
+	held := heldIps(vn)
+	if len(held) != 2 || !held["10.0.0.2"] || !held["2001:db8::5"] {
+		t.Errorf("Expected 10.0.0.2 and 2001:db8::5 to be on hold, got %v", held)
+	}
+
+	if attrs := addressRangeAttributes(vn.AddressRanges[0], map[string]interface{}{"GATEWAY": ""}); attrs["attributes"].(map[string]interface{})["GATEWAY"] != "10.0.0.254" {
+		t.Errorf("Expected the leases not to hide the attributes of the address range, got %v", attrs)
+	}
+	if attrs := addressRangeAttributes(vn.AddressRanges[0], map[string]interface{}{"gateway": ""}); attrs["attributes"].(map[string]interface{})["gateway"] != "10.0.0.254" {
+		t.Errorf("Expected the attributes of the address range to keep the spelling of the configuration, got %v", attrs)
+	}
+}
+
+func TestAccVnetNetworkMode(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVnetDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccVnetConfigVxlan,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "vn_mad", "vxlan"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "cluster_ids.#", "1"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "phydev", "eth0"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "automatic_vlan_id", "true"),
+					resource.TestCheckResourceAttrSet("opennebula_vnet.test", "vlan_id"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "mtu", "1450"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "gateway", "192.168.0.254"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "dns", "192.168.0.2 192.168.0.3"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "network_mask", "255.255.255.0"),
+				),
+			},
+			{
+				Config: testAccVnetConfigVxlanUpdate,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "guest_mtu", "1400"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "gateway", "192.168.0.1"),
+				),
+			},
+		},
+	})
+}
+
+var testAccVnetConfigVxlan = `
+resource "opennebula_vnet" "test" {
+  name = "test-vnet-vxlan"
+  description = ""
+  bridge = "br-vxlan"
+  permissions = "642"
+  cluster_ids = [0]
+  vn_mad = "vxlan"
+  phydev = "eth0"
+  automatic_vlan_id = true
+  mtu = 1450
+  gateway = "192.168.0.254"
+  dns = "192.168.0.2 192.168.0.3"
+  network_mask = "255.255.255.0"
+}
+`
+
+var testAccVnetConfigVxlanUpdate = `
+resource "opennebula_vnet" "test" {
+  name = "test-vnet-vxlan"
+  description = ""
+  bridge = "br-vxlan"
+  permissions = "642"
+  vn_mad = "vxlan"
+  phydev = "eth0"
+  automatic_vlan_id = true
+  mtu = 1450
+  guest_mtu = 1400
+  gateway = "192.168.0.1"
+  dns = "192.168.0.2 192.168.0.3"
+  network_mask = "255.255.255.0"
+}
+`
@@ -0,0 +1,28 @@
+package opennebula
+
+import "testing"
+
+func TestStateNames(t *testing.T) {
+	cases := []struct {
+		name     string
+		actual   string
+		expected string
+	}{
+		{"vm DONE", vmStateName(vmStateDone), "DONE"},
+		{"vm CLONING_FAILURE", vmStateName(vmStateCloningFailure), "CLONING_FAILURE"},
+		{"lcm RUNNING", vmLcmStateName(vmLcmStateRunning), "RUNNING"},
+		{"lcm UNKNOWN", vmLcmStateName(16), "UNKNOWN"},
+		{"lcm HOTPLUG_NIC_POWEROFF", vmLcmStateName(65), "HOTPLUG_NIC_POWEROFF"},
+		{"lcm BACKUP_POWEROFF", vmLcmStateName(70), "BACKUP_POWEROFF"},
+		{"image ERROR", imageStateName(imageStateError), "ERROR"},
+		{"image LOCKED_USED_PERS", imageStateName(imageStateLockedUsedPers), "LOCKED_USED_PERS"},
+		{"out of range", imageStateName(42), "UNKNOWN_STATE_42"},
+		{"negative", vmStateName(-1), "UNKNOWN_STATE_-1"},
+	}
+
+	for _, c := range cases {
+		if c.actual != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.name, c.expected, c.actual)
+		}
+	}
+}
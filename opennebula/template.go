@@ -0,0 +1,132 @@
+package opennebula
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/runtastic/terraform-provider-opennebula/opennebula/template"
+	"sort"
+)
+
+// validateTemplate checks that a field holds a template in OpenNebula's XML or String format
+func validateTemplate(v interface{}, k string) (ws []string, errors []error) {
+	if _, err := template.Parse(v.(string)); err != nil {
+		errors = append(errors, fmt.Errorf("%q is not a valid template: %s", k, err))
+	}
+
+	return
+}
+
+// validateTemplateKeys checks that the keys of a map field are valid attribute names, which are rendered
+// into templates as they are
+func validateTemplateKeys(v interface{}, k string) (ws []string, errors []error) {
+	for key := range v.(map[string]interface{}) {
+		if !template.ValidKey(key) {
+			errors = append(errors, fmt.Errorf("%q: %q is not a valid attribute name", k, key))
+		}
+	}
+
+	return
+}
+
+// suppressEquivalentTemplates ignores changes to a template field that don't change its meaning,
+// such as formatting, comments or the order of its keys
+func suppressEquivalentTemplates(k, old, new string, d *schema.ResourceData) bool {
+	oldTmpl, err := template.Parse(old)
+	if err != nil {
+		return false
+	}
+	newTmpl, err := template.Parse(new)
+	if err != nil {
+		return false
+	}
+
+	return oldTmpl.Equal(newTmpl)
+}
+
+// removedTemplateKeys returns the top-level keys of old that aren't in new, sorted
+func removedTemplateKeys(old, new *template.Template) []string {
+	kept := make(map[string]bool)
+	for _, key := range new.Keys() {
+		kept[key] = true
+	}
+
+	removed := make([]string, 0)
+	for _, key := range old.Keys() {
+		if !kept[key] {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(removed)
+
+	return removed
+}
+
+// pruneTemplate takes the template out of the XML info of an OpenNebula object and renders it without the given keys
+func pruneTemplate(info string, keys []string) (string, error) {
+	var object struct {
+		Template struct {
+			Inner string `xml:",innerxml"`
+		} `xml:"TEMPLATE"`
+	}
+	if err := xml.Unmarshal([]byte(info), &object); err != nil {
+		return "", err
+	}
+
+	tmpl, err := template.ParseXML("<TEMPLATE>" + object.Template.Inner + "</TEMPLATE>")
+	if err != nil {
+		return "", err
+	}
+	for _, key := range keys {
+		tmpl.Del(key)
+	}
+
+	return tmpl.XML()
+}
+
+// updateTemplate merges new into the template of the object behind the one.<kind> API, and removes the keys of
+// old that left the configuration. Attributes set at creation time or by OpenNebula itself are kept, as are the
+// ones the configuration never managed
+func updateTemplate(client *Client, kind string, id int, old, new *template.Template) error {
+	if old.Equal(new) {
+		return nil
+	}
+
+	if removed := removedTemplateKeys(old, new); len(removed) > 0 {
+		info, err := client.Call("one."+kind+".info", id, false)
+		if err != nil {
+			return err
+		}
+
+		pruned, err := pruneTemplate(info, removed)
+		if err != nil {
+			return err
+		}
+
+		// merging can't remove attributes, so those go in a replacement of the template as read back
+		if _, err = client.Call("one."+kind+".update", id, pruned, 0); err != nil {
+			return err
+		}
+	}
+
+	if len(new.Attributes) == 0 {
+		return nil
+	}
+
+	_, err := client.Call(
+		"one."+kind+".update",
+		id,
+		new.String(),
+		1, // merge with the existing template
+	)
+
+	return err
+}
+
+// oldValues reads fields of d as they were before the change being applied
+func oldValues(d *schema.ResourceData) func(string) interface{} {
+	return func(field string) interface{} {
+		old, _ := d.GetChange(field)
+		return old
+	}
+}
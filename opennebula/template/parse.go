@@ -0,0 +1,245 @@
+package template
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Parse reads a template in OpenNebula's String format, or in its XML format if it starts with <
+func Parse(s string) (*Template, error) {
+	if strings.HasPrefix(strings.TrimSpace(s), "<") {
+		return ParseXML(s)
+	}
+
+	p := &parser{input: s, line: 1}
+	t := New()
+
+	for {
+		p.skipBlank()
+		if p.done() {
+			return t, nil
+		}
+
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect('='); err != nil {
+			return nil, err
+		}
+
+		p.skipSpace()
+		if p.peek() == '[' {
+			p.next()
+			vector, err := p.vector(key)
+			if err != nil {
+				return nil, err
+			}
+			t.Append(vector)
+		} else {
+			value, err := p.value(false)
+			if err != nil {
+				return nil, err
+			}
+			t.Append(&Attribute{Key: key, Value: value})
+		}
+
+		// attributes end with the line, or share it when separated by blanks
+		end := p.pos
+		p.skipSpace()
+		if !p.done() && p.peek() != '\n' && p.peek() != '#' && p.pos == end {
+			return nil, p.errorf("expected a new attribute, found %q", p.peek())
+		}
+	}
+}
+
+type parser struct {
+	input string
+	pos   int
+	line  int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *parser) peek() byte {
+	if p.done() {
+		return 0
+	}
+
+	return p.input[p.pos]
+}
+
+func (p *parser) next() byte {
+	c := p.input[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+
+	return c
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+// skipSpace skips blanks within a line
+func (p *parser) skipSpace() {
+	for !p.done() && (p.peek() == ' ' || p.peek() == '\t' || p.peek() == '\r') {
+		p.next()
+	}
+}
+
+// skipBlank skips whitespace, newlines and comments between attributes
+func (p *parser) skipBlank() {
+	for !p.done() {
+		switch p.peek() {
+		case ' ', '\t', '\r', '\n':
+			p.next()
+		case '#':
+			for !p.done() && p.peek() != '\n' {
+				p.next()
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) expect(c byte) error {
+	p.skipBlank()
+	if p.done() {
+		return p.errorf("expected %q, found the end of the template", c)
+	}
+	if p.peek() != c {
+		return p.errorf("expected %q, found %q", c, p.peek())
+	}
+	p.next()
+
+	return nil
+}
+
+// key reads an attribute name: a letter or underscore followed by letters, digits, underscores, dashes or dots
+func (p *parser) key() (string, error) {
+	start := p.pos
+	for !p.done() && isKeyChar(p.peek(), p.pos == start) {
+		p.next()
+	}
+
+	if p.pos == start {
+		if p.done() {
+			return "", p.errorf("expected an attribute name, found the end of the template")
+		}
+		return "", p.errorf("expected an attribute name, found %q", p.peek())
+	}
+
+	return p.input[start:p.pos], nil
+}
+
+func isKeyChar(c byte, first bool) bool {
+	switch {
+	case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c == '_':
+		return true
+	case c >= '0' && c <= '9', c == '-', c == '.':
+		return !first
+	}
+
+	return false
+}
+
+// value reads a quoted or bare value. Bare values run to the next blank, or to the next , or ] within vectors
+func (p *parser) value(inVector bool) (string, error) {
+	if p.done() {
+		return "", p.errorf("expected a value, found the end of the template")
+	}
+
+	if p.peek() == '"' {
+		return p.quoted()
+	}
+
+	start := p.pos
+	for !p.done() {
+		c := p.peek()
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '"' || c == '[' || c == ']' || c == ',' {
+			break
+		}
+		p.next()
+	}
+
+	if p.pos == start {
+		return "", p.errorf("expected a value, found %q", p.peek())
+	}
+	if !inVector && !p.done() && (p.peek() == ']' || p.peek() == ',') {
+		return "", p.errorf("unexpected %q after a value", p.peek())
+	}
+
+	return p.input[start:p.pos], nil
+}
+
+// quoted reads a quoted value, which may span lines. \" and \\ are unescaped, other backslashes are kept
+func (p *parser) quoted() (string, error) {
+	line := p.line
+	p.next()
+
+	var b strings.Builder
+	for !p.done() {
+		c := p.next()
+		switch {
+		case c == '"':
+			return b.String(), nil
+		case c == '\\' && (p.peek() == '"' || p.peek() == '\\'):
+			b.WriteByte(p.next())
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return "", fmt.Errorf("line %d: unterminated quoted value", line)
+}
+
+// vector reads the pairs of a vector attribute, after its opening [
+func (p *parser) vector(key string) (*Attribute, error) {
+	vector := NewVector(key)
+
+	p.skipBlank()
+	if p.peek() == ']' {
+		p.next()
+		return vector, nil
+	}
+
+	for {
+		p.skipBlank()
+		k, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect('='); err != nil {
+			return nil, err
+		}
+
+		p.skipBlank()
+		if p.peek() == '[' {
+			return nil, p.errorf("vector attributes can't be nested within %s", key)
+		}
+		v, err := p.value(true)
+		if err != nil {
+			return nil, err
+		}
+		vector.Vector = append(vector.Vector, Pair{Key: k, Value: v})
+
+		p.skipBlank()
+		switch {
+		case p.done():
+			return nil, p.errorf("unterminated vector attribute %s", key)
+		case p.peek() == ',':
+			p.next()
+		case p.peek() == ']':
+			p.next()
+			return vector, nil
+		default:
+			return nil, p.errorf("expected , or ] in vector attribute %s, found %q", key, p.peek())
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package template
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected []*Attribute
+	}{
+		{"", []*Attribute{}},
+		{"  # only a comment\n\n", []*Attribute{}},
+		{
+			"name = \"web\"\nMEMORY=512 CPU = 0.5 # trailing comment\n",
+			[]*Attribute{
+				{Key: "name", Value: "web"},
+				{Key: "MEMORY", Value: "512"},
+				{Key: "CPU", Value: "0.5"},
+			},
+		},
+		{
+			"SCRIPT = \"#!/bin/sh\necho \\\"hi\\\" \\\\ C:\\tmp\"\nEMPTY = \"\"",
+			[]*Attribute{
+				{Key: "SCRIPT", Value: "#!/bin/sh\necho \"hi\" \\ C:\\tmp"},
+				{Key: "EMPTY", Value: ""},
+			},
+		},
+		{
+			"DISK = [\n  IMAGE_ID = \"3\", # the OS\n  dev_prefix = vd ]\nNIC=[NETWORK_ID=1]\nCONTEXT = [ ]",
+			[]*Attribute{
+				{Key: "DISK", Vector: []Pair{{"IMAGE_ID", "3"}, {"dev_prefix", "vd"}}},
+				{Key: "NIC", Vector: []Pair{{"NETWORK_ID", "1"}}},
+				{Key: "CONTEXT", Vector: []Pair{}},
+			},
+		},
+		{
+			"URL = http://example.com/a=b#c\nBRIDGE=br0",
+			[]*Attribute{
+				{Key: "URL", Value: "http://example.com/a=b#c"},
+				{Key: "BRIDGE", Value: "br0"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		tmpl, err := Parse(c.input)
+		if err != nil {
+			t.Errorf("%q: err: %s", c.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(tmpl.Attributes, c.expected) {
+			t.Errorf("%q: expected %q, got %q", c.input, (&Template{c.expected}).String(), tmpl.String())
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, invalid := range []string{
+		"NAME",
+		"NAME =",
+		"NAME = \n\"web\"",
+		"= web",
+		"1NAME = web",
+		"TWO WORDS = web",
+		"NAME = \"web",
+		"NAME = \"web\"CPU = 1",
+		"NAME = web]",
+		"DISK = [ IMAGE_ID = 1",
+		"DISK = [ IMAGE_ID = 1 SIZE = 2 ]",
+		"DISK = [ IMAGE_ID = 1, ]",
+		"DISK = [ NESTED = [ A = b ] ]",
+		"DISK = [ IMAGE_ID ]",
+	} {
+		if tmpl, err := Parse(invalid); err == nil {
+			t.Errorf("Expected %q to be invalid, got %q", invalid, tmpl)
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"NAME = \"web\"\nMEMORY = 512\n",
+		"DISK = [\n  IMAGE_ID = \"3\",\n  SIZE = \"1024\" ]\nDISK = [ IMAGE_ID = 4 ]\n",
+		"# comment\nSCRIPT = \"a \\\"quoted\\\" \\\\ line\nand another\"\n",
+		"CONTEXT = [ ]",
+		"<TEMPLATE><NIC><NETWORK_ID>1</NETWORK_ID></NIC></TEMPLATE>",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		tmpl, err := Parse(input)
+		if err != nil {
+			return
+		}
+
+		// rendering is stable and reads back to the same template
+		rendered := tmpl.String()
+		again, err := Parse(rendered)
+		if err != nil {
+			t.Fatalf("Rendered template %q doesn't parse: %s", rendered, err)
+		}
+		if !reflect.DeepEqual(again.Attributes, tmpl.Attributes) {
+			t.Fatalf("Template %q reads back as %q", rendered, again.String())
+		}
+		if again.String() != rendered {
+			t.Fatalf("Rendering of %q isn't stable", rendered)
+		}
+		if !again.Equal(tmpl) {
+			t.Fatalf("Template %q isn't equal to itself", rendered)
+		}
+
+		checkXMLRoundTrip(t, tmpl)
+	})
+}
@@ -0,0 +1,250 @@
+// Package template reads and writes OpenNebula's template language, both in its String format:
+//
+//	NAME = "web"
+//	MEMORY = 512 # comments run to the end of the line
+//	DISK = [
+//	  IMAGE_ID = "3",
+//	  SIZE = "1024" ]
+//
+// and in the XML format OpenNebula returns templates in.
+//
+// Keys keep the case they're written in, as some of OpenNebula's do (ICMPv6_TYPE), but are looked up and
+// compared regardless of case. A key can appear more than once, as DISK or NIC do, and the attributes of a
+// template keep their order.
+package template
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Pair is a key and its value within a vector attribute
+type Pair struct {
+	Key   string
+	Value string
+}
+
+// Attribute is a top-level attribute of a template. Vector attributes have a non-nil Vector and no Value
+type Attribute struct {
+	Key    string
+	Value  string
+	Vector []Pair
+}
+
+// Template is an ordered list of attributes
+type Template struct {
+	Attributes []*Attribute
+}
+
+// New returns an empty template
+func New() *Template {
+	return &Template{Attributes: make([]*Attribute, 0)}
+}
+
+// NewVector returns an empty vector attribute
+func NewVector(key string) *Attribute {
+	return &Attribute{Key: key, Vector: make([]Pair, 0)}
+}
+
+// IsVector tells whether the attribute holds a vector rather than a single value
+func (a *Attribute) IsVector() bool {
+	return a.Vector != nil
+}
+
+// Add appends a key to a vector attribute. Values are formatted as fmt.Sprint does
+func (a *Attribute) Add(key string, value interface{}) *Attribute {
+	if a.Vector == nil {
+		a.Vector = make([]Pair, 0)
+	}
+	a.Vector = append(a.Vector, Pair{Key: key, Value: fmt.Sprint(value)})
+
+	return a
+}
+
+// Get returns the value of key within a vector attribute, or false if the vector doesn't hold it
+func (a *Attribute) Get(key string) (string, bool) {
+	for _, pair := range a.Vector {
+		if strings.EqualFold(pair.Key, key) {
+			return pair.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// Validate checks the keys of the attribute, which String renders as they are
+func (a *Attribute) Validate() error {
+	if !ValidKey(a.Key) {
+		return fmt.Errorf("%q is not a valid attribute name", a.Key)
+	}
+	for _, pair := range a.Vector {
+		if !ValidKey(pair.Key) {
+			return fmt.Errorf("%q is not a valid attribute name in %s", pair.Key, a.Key)
+		}
+	}
+
+	return nil
+}
+
+// String renders the attribute in OpenNebula's String format, without a trailing newline. Keys are
+// rendered as they are, so attributes with keys that don't come from the code have to be validated first
+func (a *Attribute) String() string {
+	if !a.IsVector() {
+		return fmt.Sprintf("%s = %s", a.Key, quote(a.Value))
+	}
+
+	if len(a.Vector) == 0 {
+		return fmt.Sprintf("%s = [ ]", a.Key)
+	}
+
+	pairs := make([]string, 0, len(a.Vector))
+	for _, pair := range a.Vector {
+		pairs = append(pairs, fmt.Sprintf("%s = %s", pair.Key, quote(pair.Value)))
+	}
+
+	return fmt.Sprintf("%s = [\n  %s ]", a.Key, strings.Join(pairs, ",\n  "))
+}
+
+// Add appends a single valued attribute. Values are formatted as fmt.Sprint does
+func (t *Template) Add(key string, value interface{}) *Template {
+	t.Attributes = append(t.Attributes, &Attribute{Key: key, Value: fmt.Sprint(value)})
+
+	return t
+}
+
+// AddVector appends an empty vector attribute and returns it, to be filled in
+func (t *Template) AddVector(key string) *Attribute {
+	vector := NewVector(key)
+	t.Attributes = append(t.Attributes, vector)
+
+	return vector
+}
+
+// Append appends the given attributes, or the ones of other templates through Append(other.Attributes...)
+func (t *Template) Append(attrs ...*Attribute) *Template {
+	t.Attributes = append(t.Attributes, attrs...)
+
+	return t
+}
+
+// Get returns every attribute with the given key, in order
+func (t *Template) Get(key string) []*Attribute {
+	attrs := make([]*Attribute, 0)
+	for _, attr := range t.Attributes {
+		if strings.EqualFold(attr.Key, key) {
+			attrs = append(attrs, attr)
+		}
+	}
+
+	return attrs
+}
+
+// Value returns the value of the first single valued attribute with the given key
+func (t *Template) Value(key string) (string, bool) {
+	for _, attr := range t.Get(key) {
+		if !attr.IsVector() {
+			return attr.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// Del removes every attribute with the given key
+func (t *Template) Del(key string) *Template {
+	kept := make([]*Attribute, 0, len(t.Attributes))
+	for _, attr := range t.Attributes {
+		if !strings.EqualFold(attr.Key, key) {
+			kept = append(kept, attr)
+		}
+	}
+	t.Attributes = kept
+
+	return t
+}
+
+// Keys returns the distinct keys of the template, upper-cased, in the order they first appear
+func (t *Template) Keys() []string {
+	keys := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, attr := range t.Attributes {
+		key := strings.ToUpper(attr.Key)
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// Validate checks the keys of every attribute of the template, as parsed templates already are
+func (t *Template) Validate() error {
+	for _, attr := range t.Attributes {
+		if err := attr.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// String renders the template in OpenNebula's String format, one attribute per line. As with
+// Attribute.String, templates with keys that don't come from the code have to be validated first
+func (t *Template) String() string {
+	var b strings.Builder
+	for _, attr := range t.Attributes {
+		b.WriteString(attr.String())
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+// Equal tells whether two templates mean the same to OpenNebula: keys are compared regardless of case, and
+// neither the order of different keys nor the order of the keys within a vector matter. The order of the
+// attributes that share a key does, as it's the order of a VM's disks or NICs
+func (t *Template) Equal(other *Template) bool {
+	return t.canonical() == other.canonical()
+}
+
+// canonical renders the template with its attributes grouped and sorted by key
+func (t *Template) canonical() string {
+	attrs := make([]*Attribute, 0, len(t.Attributes))
+	for _, attr := range t.Attributes {
+		c := &Attribute{Key: strings.ToUpper(attr.Key), Value: attr.Value}
+		if attr.IsVector() {
+			c.Value = ""
+			c.Vector = make([]Pair, 0, len(attr.Vector))
+			for _, pair := range attr.Vector {
+				c.Vector = append(c.Vector, Pair{Key: strings.ToUpper(pair.Key), Value: pair.Value})
+			}
+			sort.SliceStable(c.Vector, func(i, j int) bool { return c.Vector[i].Key < c.Vector[j].Key })
+		}
+		attrs = append(attrs, c)
+	}
+	sort.SliceStable(attrs, func(i, j int) bool { return attrs[i].Key < attrs[j].Key })
+
+	return (&Template{Attributes: attrs}).String()
+}
+
+// ValidKey tells whether key is a valid attribute name, both in the String format and as an XML element
+func ValidKey(key string) bool {
+	if key == "" {
+		return false
+	}
+
+	for i := 0; i < len(key); i++ {
+		if !isKeyChar(key[i], i == 0) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// quote renders a value as a String format string, escaping backslashes and quotes
+func quote(value string) string {
+	return "\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(value) + "\""
+}
@@ -0,0 +1,112 @@
+package template
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTemplateString(t *testing.T) {
+	tmpl := New().Add("NAME", "web \"frontend\"").Add("MEMORY", 512)
+	tmpl.AddVector("DISK").Add("IMAGE_ID", 3).Add("ICMPv6_TYPE", "128")
+	tmpl.AddVector("CONTEXT")
+	tmpl.Add("PATH", "C:\\images")
+
+	expected := "NAME = \"web \\\"frontend\\\"\"\n" +
+		"MEMORY = \"512\"\n" +
+		"DISK = [\n  IMAGE_ID = \"3\",\n  ICMPv6_TYPE = \"128\" ]\n" +
+		"CONTEXT = [ ]\n" +
+		"PATH = \"C:\\\\images\"\n"
+	if s := tmpl.String(); s != expected {
+		t.Errorf("Expected %q, got %q", expected, s)
+	}
+
+	if s := NewVector("AR").Add("SIZE", 5).String(); s != "AR = [\n  SIZE = \"5\" ]" {
+		t.Errorf("Unexpected rendering of a single vector attribute: %q", s)
+	}
+}
+
+func TestTemplateAccessors(t *testing.T) {
+	tmpl := New().Add("NAME", "web").Add("NIC", "not a vector")
+	tmpl.AddVector("NIC").Add("NETWORK_ID", 1)
+	tmpl.AddVector("NIC").Add("NETWORK_ID", 2)
+
+	if keys := tmpl.Keys(); !reflect.DeepEqual(keys, []string{"NAME", "NIC"}) {
+		t.Errorf("Unexpected keys %v", keys)
+	}
+
+	nics := tmpl.Get("nic")
+	if len(nics) != 3 || nics[0].IsVector() || !nics[2].IsVector() {
+		t.Fatalf("Unexpected NICs %v", nics)
+	}
+	if id, ok := nics[2].Get("network_id"); !ok || id != "2" {
+		t.Errorf("Expected NETWORK_ID 2, got %q", id)
+	}
+	if _, ok := nics[2].Get("IP"); ok {
+		t.Errorf("Expected no IP in %s", nics[2])
+	}
+
+	if value, ok := tmpl.Value("NIC"); !ok || value != "not a vector" {
+		t.Errorf("Expected the single valued NIC, got %q", value)
+	}
+	if _, ok := tmpl.Value("MISSING"); ok {
+		t.Errorf("Expected no value for a missing key")
+	}
+
+	tmpl.Del("nic")
+	if s := tmpl.String(); s != "NAME = \"web\"\n" {
+		t.Errorf("Expected only NAME to be left, got %q", s)
+	}
+}
+
+func TestTemplateEqual(t *testing.T) {
+	base := "NAME = web\nDISK = [ IMAGE_ID = 1, SIZE = 2 ]\nDISK = [ IMAGE_ID = 2 ]\n"
+
+	for _, equal := range []string{
+		"disk = [ size = \"2\", image_id = \"1\" ]\n# comment\nNAME = \"web\"\nDISK = [ IMAGE_ID = 2 ]",
+		"<TEMPLATE><DISK><IMAGE_ID>1</IMAGE_ID><SIZE>2</SIZE></DISK><NAME>web</NAME><DISK><IMAGE_ID>2</IMAGE_ID></DISK></TEMPLATE>",
+	} {
+		if !mustParse(t, base).Equal(mustParse(t, equal)) {
+			t.Errorf("Expected %q to equal %q", equal, base)
+		}
+	}
+
+	for _, different := range []string{
+		"NAME = web\nDISK = [ IMAGE_ID = 2 ]\nDISK = [ IMAGE_ID = 1, SIZE = 2 ]\n",
+		"NAME = web\nDISK = [ IMAGE_ID = 1, SIZE = 2 ]\n",
+		"NAME = Web\nDISK = [ IMAGE_ID = 1, SIZE = 2 ]\nDISK = [ IMAGE_ID = 2 ]\n",
+		"NAME = web\nDISK = [ IMAGE_ID = 1, SIZE = 2 ]\nDISK = 2\n",
+	} {
+		if mustParse(t, base).Equal(mustParse(t, different)) {
+			t.Errorf("Expected %q to differ from %q", different, base)
+		}
+	}
+}
+
+func mustParse(t *testing.T, s string) *Template {
+	tmpl, err := Parse(s)
+	if err != nil {
+		t.Fatalf("Parsing %q: %s", s, err)
+	}
+
+	return tmpl
+}
+
+func TestTemplateValidate(t *testing.T) {
+	valid := New().Add("NAME", "web").Add("ICMPv6_TYPE", 128)
+	valid.AddVector("DISK").Add("IMAGE_ID", 3).Add("dev.prefix-2", "vd")
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Expected %q to be valid: %s", valid.String(), err)
+	}
+
+	for _, invalid := range []*Template{
+		New().Add("", "web"),
+		New().Add("NAME = \"web\"\nMEMORY", "512"),
+		New().Add("1NAME", "web"),
+		New().Append(NewVector("DISK").Add("IMAGE_ID = 3, SIZE", "1")),
+		New().Append(NewVector("NIC ]\nDISK").Add("IMAGE_ID", "1")),
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Expected %q to be invalid", invalid.String())
+		}
+	}
+}
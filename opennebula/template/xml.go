@@ -0,0 +1,231 @@
+package template
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// ParseXML reads a template in OpenNebula's XML format, such as the TEMPLATE element of an object's info.
+// The name of the root element doesn't matter. Elements holding other elements are vector attributes
+func ParseXML(s string) (*Template, error) {
+	decoder := xml.NewDecoder(strings.NewReader(s))
+	t := New()
+
+	root, err := nextElement(decoder)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return nil, fmt.Errorf("Expected a root element in XML template")
+	}
+
+	for {
+		start, end, err := nextChild(decoder)
+		if err != nil {
+			return nil, err
+		}
+		if end {
+			break
+		}
+
+		attr, err := readAttribute(decoder, start)
+		if err != nil {
+			return nil, err
+		}
+		t.Append(attr)
+	}
+
+	// nothing but whitespace, comments and processing instructions may follow the root element
+	if trailing, err := nextElement(decoder); err != nil || trailing != nil {
+		return nil, fmt.Errorf("Unexpected content after the root element of XML template")
+	}
+
+	return t, nil
+}
+
+// nextElement skips to the next start element, returning nil at the end of the input
+func nextElement(decoder *xml.Decoder) (*xml.StartElement, error) {
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch tok := token.(type) {
+		case xml.StartElement:
+			return &tok, nil
+		case xml.CharData:
+			if strings.TrimSpace(string(tok)) != "" {
+				return nil, fmt.Errorf("Unexpected text %q outside of the elements of XML template", string(tok))
+			}
+		case xml.EndElement:
+			return nil, fmt.Errorf("Unexpected end of element %s in XML template", tok.Name.Local)
+		}
+	}
+}
+
+// nextChild returns the next child element of the current one, or end once the current element ends.
+// Text between child elements must be whitespace
+func nextChild(decoder *xml.Decoder) (start xml.StartElement, end bool, err error) {
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return start, false, err
+		}
+
+		switch tok := token.(type) {
+		case xml.StartElement:
+			return tok, false, nil
+		case xml.EndElement:
+			return start, true, nil
+		case xml.CharData:
+			if strings.TrimSpace(string(tok)) != "" {
+				return start, false, fmt.Errorf("Unexpected text %q between the elements of XML template", string(tok))
+			}
+		}
+	}
+}
+
+// elementKey returns the key an element stands for, which must be a valid String format key as well
+func elementKey(element xml.StartElement) (string, error) {
+	if element.Name.Space != "" || !ValidKey(element.Name.Local) {
+		return "", fmt.Errorf("%q is not a valid attribute name in XML template", element.Name.Local)
+	}
+
+	return element.Name.Local, nil
+}
+
+// readAttribute reads the attribute started by start, up to its end
+func readAttribute(decoder *xml.Decoder, start xml.StartElement) (*Attribute, error) {
+	key, err := elementKey(start)
+	if err != nil {
+		return nil, err
+	}
+
+	var text strings.Builder
+	var vector *Attribute
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch tok := token.(type) {
+		case xml.CharData:
+			text.Write(tok)
+		case xml.StartElement:
+			if vector == nil {
+				if strings.TrimSpace(text.String()) != "" {
+					return nil, fmt.Errorf("Attribute %s of XML template mixes text and elements", key)
+				}
+				vector = NewVector(key)
+			}
+			pairKey, err := elementKey(tok)
+			if err != nil {
+				return nil, err
+			}
+			value, err := readValue(decoder, key, tok)
+			if err != nil {
+				return nil, err
+			}
+			vector.Vector = append(vector.Vector, Pair{Key: pairKey, Value: value})
+			text.Reset()
+		case xml.EndElement:
+			if vector != nil {
+				if strings.TrimSpace(text.String()) != "" {
+					return nil, fmt.Errorf("Attribute %s of XML template mixes text and elements", key)
+				}
+				return vector, nil
+			}
+			return &Attribute{Key: key, Value: text.String()}, nil
+		}
+	}
+}
+
+// readValue reads the text of a key within a vector attribute, up to its end
+func readValue(decoder *xml.Decoder, vector string, start xml.StartElement) (string, error) {
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", err
+		}
+
+		switch tok := token.(type) {
+		case xml.CharData:
+			text.Write(tok)
+		case xml.StartElement:
+			return "", fmt.Errorf("Vector attribute %s of XML template can't nest %s within %s", vector, tok.Name.Local, start.Name.Local)
+		case xml.EndElement:
+			return text.String(), nil
+		}
+	}
+}
+
+// XML renders the template in OpenNebula's XML format, with values in CDATA sections as OpenNebula does.
+// An empty vector attribute renders as an empty element, which reads back as an empty value
+func (t *Template) XML() (string, error) {
+	if err := t.Validate(); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString("<TEMPLATE>")
+
+	for _, attr := range t.Attributes {
+		b.WriteString("<" + attr.Key + ">")
+		if attr.IsVector() {
+			for _, pair := range attr.Vector {
+				b.WriteString("<" + pair.Key + ">")
+				if err := writeXMLValue(&b, pair.Value); err != nil {
+					return "", fmt.Errorf("Value of %s in %s: %s", pair.Key, attr.Key, err)
+				}
+				b.WriteString("</" + pair.Key + ">")
+			}
+		} else if err := writeXMLValue(&b, attr.Value); err != nil {
+			return "", fmt.Errorf("Value of %s: %s", attr.Key, err)
+		}
+		b.WriteString("</" + attr.Key + ">")
+	}
+
+	b.WriteString("</TEMPLATE>")
+	return b.String(), nil
+}
+
+// writeXMLValue writes value in a CDATA section, or as escaped text if a CDATA section can't hold it as is
+func writeXMLValue(b *strings.Builder, value string) error {
+	if !utf8.ValidString(value) {
+		return fmt.Errorf("%q is not valid UTF-8", value)
+	}
+	for _, r := range value {
+		if !validXMLChar(r) {
+			return fmt.Errorf("%q can't be represented in XML", r)
+		}
+	}
+
+	if value == "" {
+		return nil
+	}
+
+	// XML parsers turn carriage returns into newlines within CDATA sections
+	if strings.Contains(value, "]]>") || strings.Contains(value, "\r") {
+		return xml.EscapeText(b, []byte(value))
+	}
+
+	b.WriteString("<![CDATA[" + value + "]]>")
+	return nil
+}
+
+// validXMLChar tells whether r is allowed in an XML 1.0 document
+func validXMLChar(r rune) bool {
+	return r == 0x09 || r == 0x0A || r == 0x0D ||
+		(r >= 0x20 && r <= 0xD7FF) ||
+		(r >= 0xE000 && r <= 0xFFFD) ||
+		(r >= 0x10000 && r <= 0x10FFFF)
+}
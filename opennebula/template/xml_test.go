@@ -0,0 +1,135 @@
+package template
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestXML(t *testing.T) {
+	tmpl := New().Add("NAME", "web & <db>").Add("SCRIPT", "ends with ]]> in it").Add("CRLF", "a\r\nb")
+	tmpl.AddVector("NIC").Add("NETWORK_ID", 1).Add("IP", "")
+
+	xml, err := tmpl.XML()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := "<TEMPLATE><NAME><![CDATA[web & <db>]]></NAME>" +
+		"<SCRIPT>ends with ]]&gt; in it</SCRIPT>" +
+		"<CRLF>a&#xD;&#xA;b</CRLF>" +
+		"<NIC><NETWORK_ID><![CDATA[1]]></NETWORK_ID><IP></IP></NIC></TEMPLATE>"
+	if xml != expected {
+		t.Errorf("Expected %q, got %q", expected, xml)
+	}
+
+	parsed, err := ParseXML(xml)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(parsed.Attributes, tmpl.Attributes) {
+		t.Errorf("Expected %q to read back as %q, got %q", xml, tmpl.String(), parsed.String())
+	}
+
+	for _, invalid := range []*Template{
+		New().Add("NAME", "bell \a"),
+		New().Add("NAME", "\xff"),
+		New().Add("TWO WORDS", "web"),
+	} {
+		if xml, err := invalid.XML(); err == nil {
+			t.Errorf("Expected %q not to render to XML, got %q", invalid.String(), xml)
+		}
+	}
+}
+
+func TestParseXML(t *testing.T) {
+	input := `<?xml version="1.0"?>
+<VNET_TEMPLATE>
+  <!-- as written by hand -->
+  <bridge>br0</bridge>
+  <AR>
+    <TYPE><![CDATA[IP4]]></TYPE>
+    <SIZE>10</SIZE>
+  </AR>
+  <EMPTY/>
+</VNET_TEMPLATE>
+`
+	tmpl, err := Parse(input)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []*Attribute{
+		{Key: "bridge", Value: "br0"},
+		{Key: "AR", Vector: []Pair{{"TYPE", "IP4"}, {"SIZE", "10"}}},
+		{Key: "EMPTY", Value: ""},
+	}
+	if !reflect.DeepEqual(tmpl.Attributes, expected) {
+		t.Errorf("Expected %q, got %q", (&Template{expected}).String(), tmpl.String())
+	}
+
+	for _, invalid := range []string{
+		"",
+		"<TEMPLATE>",
+		"<TEMPLATE>text</TEMPLATE>",
+		"<TEMPLATE><A>1</A></TEMPLATE><B/>",
+		"<TEMPLATE><NIC>text<IP>1</IP></NIC></TEMPLATE>",
+		"<TEMPLATE><NIC><IP><V>1</V></IP></NIC></TEMPLATE>",
+		"<TEMPLATE><x:NAME xmlns:x=\"urn:x\">web</x:NAME></TEMPLATE>",
+		"<TEMPLATE><NAME>web</name></TEMPLATE>",
+	} {
+		if tmpl, err := ParseXML(invalid); err == nil {
+			t.Errorf("Expected %q to be invalid, got %q", invalid, tmpl)
+		}
+	}
+}
+
+func FuzzParseXML(f *testing.F) {
+	for _, seed := range []string{
+		"<TEMPLATE><NAME><![CDATA[web]]></NAME></TEMPLATE>",
+		"<VNET><AR><TYPE>IP4</TYPE><SIZE>10</SIZE></AR><AR/></VNET>",
+		"<TEMPLATE>\n  <SCRIPT>a &amp; b&#xD;</SCRIPT>\n</TEMPLATE>",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		tmpl, err := ParseXML(input)
+		if err != nil {
+			return
+		}
+
+		// whatever reads from XML renders to the String format and back
+		again, err := Parse(tmpl.String())
+		if err != nil {
+			t.Fatalf("Rendered template %q doesn't parse: %s", tmpl.String(), err)
+		}
+		if !reflect.DeepEqual(again.Attributes, tmpl.Attributes) {
+			t.Fatalf("Template %q reads back as %q", tmpl.String(), again.String())
+		}
+
+		checkXMLRoundTrip(t, tmpl)
+	})
+}
+
+// checkXMLRoundTrip checks that a template that renders to XML reads back from it unchanged
+func checkXMLRoundTrip(t *testing.T, tmpl *Template) {
+	for _, attr := range tmpl.Attributes {
+		if attr.IsVector() && len(attr.Vector) == 0 {
+			// empty vectors read back as empty values
+			return
+		}
+	}
+
+	xml, err := tmpl.XML()
+	if err != nil {
+		return
+	}
+
+	parsed, err := ParseXML(xml)
+	if err != nil {
+		t.Fatalf("Rendered XML %q doesn't parse: %s", xml, err)
+	}
+	if !reflect.DeepEqual(parsed.Attributes, tmpl.Attributes) {
+		t.Fatalf("XML %q reads back as %q instead of %q", xml, parsed.String(), tmpl.String())
+	}
+}
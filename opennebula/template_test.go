@@ -0,0 +1,108 @@
+package opennebula
+
+import (
+	"github.com/runtastic/terraform-provider-opennebula/opennebula/template"
+	"reflect"
+	"testing"
+)
+
+func TestRemovedTemplateKeys(t *testing.T) {
+	cases := []struct {
+		old      string
+		new      string
+		expected []string
+	}{
+		{"", "", []string{}},
+		{"FOO = \"bar\"\nbaz=qux\n", "", []string{"BAZ", "FOO"}},
+		{"DISK = [ IMAGE = \"x\" ]\nDISK = [ IMAGE = \"y\" ]\nMEMORY = 64", "disk = [ IMAGE = \"z\" ]", []string{"MEMORY"}},
+		{"<TEMPLATE><FOO><![CDATA[bar]]></FOO><nic><NETWORK_ID>1</NETWORK_ID></nic></TEMPLATE>", "FOO = baz", []string{"NIC"}},
+	}
+
+	for _, c := range cases {
+		keys := removedTemplateKeys(mustParseTemplate(t, c.old), mustParseTemplate(t, c.new))
+		if !reflect.DeepEqual(keys, c.expected) {
+			t.Errorf("%q -> %q: expected removed keys %v, got %v", c.old, c.new, c.expected, keys)
+		}
+	}
+}
+
+func TestValidateTemplateKeys(t *testing.T) {
+	if _, errs := validateTemplateKeys(map[string]interface{}{"GATEWAY": "10.0.0.1", "dns": "10.0.0.2"}, "attributes"); len(errs) != 0 {
+		t.Errorf("Expected valid keys, got %v", errs)
+	}
+
+	if _, errs := validateTemplateKeys(map[string]interface{}{"GATEWAY = \"x\"\nDNS": "10.0.0.2", "": "y"}, "attributes"); len(errs) != 2 {
+		t.Errorf("Expected 2 invalid keys, got %v", errs)
+	}
+}
+
+func TestPruneTemplate(t *testing.T) {
+	info := "<VNET><ID>3</ID><TEMPLATE><BRIDGE>br0</BRIDGE><FOO><![CDATA[bar]]></FOO>" +
+		"<AR><TYPE>IP4</TYPE></AR></TEMPLATE></VNET>"
+
+	pruned, err := pruneTemplate(info, []string{"FOO"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := "<TEMPLATE><BRIDGE><![CDATA[br0]]></BRIDGE><AR><TYPE><![CDATA[IP4]]></TYPE></AR></TEMPLATE>"
+	if pruned != expected {
+		t.Errorf("Expected %q, got %q", expected, pruned)
+	}
+
+	if pruned, err := pruneTemplate("<VNET><TEMPLATE><A>1<B/></A></TEMPLATE></VNET>", nil); err == nil {
+		t.Errorf("Expected an invalid template to fail, got %q", pruned)
+	}
+}
+
+func TestUpdateTemplate(t *testing.T) {
+	info := "<VNET><ID>3</ID><TEMPLATE><BRIDGE><![CDATA[br0]]></BRIDGE><FOO><![CDATA[bar]]></FOO>" +
+		"<SECURITY_GROUPS><![CDATA[0]]></SECURITY_GROUPS><VN_MAD><![CDATA[dummy]]></VN_MAD></TEMPLATE></VNET>"
+
+	client, standIn := newTestClient(t, map[string]func([]string) (interface{}, error){
+		"one.vn.info":   func(params []string) (interface{}, error) { return info, nil },
+		"one.vn.update": func(params []string) (interface{}, error) { return 3, nil },
+	})
+	defer standIn.Close()
+
+	if err := updateTemplate(client, "vn", 3, mustParseTemplate(t, "FOO = \"bar\"\nBRIDGE=br0\n"), mustParseTemplate(t, "BRIDGE=br1\n")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []xmlRpcCall{
+		{Method: "one.vn.info", Params: []string{"3", "0"}},
+		{Method: "one.vn.update", Params: []string{"3", "<TEMPLATE><BRIDGE><![CDATA[br0]]></BRIDGE>" +
+			"<SECURITY_GROUPS><![CDATA[0]]></SECURITY_GROUPS><VN_MAD><![CDATA[dummy]]></VN_MAD></TEMPLATE>", "0"}},
+		{Method: "one.vn.update", Params: []string{"3", "BRIDGE = \"br1\"\n", "1"}},
+	}
+	standIn.checkCalls(t, expected)
+
+	// nothing left the configuration, so the template isn't read back
+	standIn.Calls = nil
+	if err := updateTemplate(client, "vn", 3, mustParseTemplate(t, "FOO = \"bar\"\n"), mustParseTemplate(t, "FOO = \"baz\"\n")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected = []xmlRpcCall{
+		{Method: "one.vn.update", Params: []string{"3", "FOO = \"baz\"\n", "1"}},
+	}
+	standIn.checkCalls(t, expected)
+
+	// reformatting the same template changes nothing
+	standIn.Calls = nil
+	if err := updateTemplate(client, "vn", 3, mustParseTemplate(t, "FOO=baz BRIDGE=br1"), mustParseTemplate(t, "BRIDGE = \"br1\"\nFOO = \"baz\"\n")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(standIn.Calls) != 0 {
+		t.Errorf("Expected no calls for an equivalent template, got %v", standIn.Calls)
+	}
+}
+
+func mustParseTemplate(t *testing.T, s string) *template.Template {
+	tmpl, err := template.Parse(s)
+	if err != nil {
+		t.Fatalf("Parsing %q: %s", s, err)
+	}
+
+	return tmpl
+}
@@ -0,0 +1,142 @@
+package opennebula
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// progressReader logs how much of a file has been read, every 10%
+type progressReader struct {
+	reader   io.Reader
+	name     string
+	total    int64
+	read     int64
+	reported int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.reader.Read(b)
+	p.read += int64(n)
+
+	if n > 0 && p.total > 0 {
+		if percent := p.read * 100 / p.total; percent/10 > p.reported/10 || p.read == p.total {
+			p.reported = percent
+			log.Printf("[INFO] Uploaded %d%% of %s (%d/%d bytes)", percent, p.name, p.read, p.total)
+		}
+	}
+
+	return n, err
+}
+
+// Upload streams a local file to the upload endpoint of Sunstone/FireEdge.
+// It returns the temporary path of the file on the OpenNebula frontend, which
+// can be used as the PATH of an Image, and the SHA1 checksum of the file.
+func (c *Client) Upload(path string) (string, string, error) {
+	if c.UploadEndpoint == "" {
+		return "", "", fmt.Errorf("Uploading %s requires the provider's upload_endpoint to be set", path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", "", err
+	}
+
+	hash := sha1.New()
+	progress := &progressReader{
+		reader: io.TeeReader(file, hash),
+		name:   path,
+		total:  info.Size(),
+	}
+
+	body, writer := io.Pipe()
+	defer body.Close()
+	form := multipart.NewWriter(writer)
+	go func() {
+		part, err := form.CreateFormFile("file", filepath.Base(path))
+		if err == nil {
+			_, err = io.Copy(part, progress)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequest("POST", c.UploadEndpoint, body)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.SetBasicAuth(c.Username, c.Password)
+
+	log.Printf("[INFO] Uploading %s (%d bytes) to %s", path, info.Size(), c.UploadEndpoint)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", err
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return "", "", fmt.Errorf("Uploading %s failed with status %s: %s", path, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+
+	tmpPath := uploadedPath(respBody)
+	if tmpPath == "" {
+		return "", "", fmt.Errorf("Uploading %s did not return the path of the file on the frontend", path)
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	log.Printf("[INFO] Uploaded %s to %s (SHA1 %s)", path, tmpPath, checksum)
+
+	return tmpPath, checksum, nil
+}
+
+// uploadedPath reads the temporary path from the response of the upload endpoint,
+// which is either a JSON document ({"tmpfile": "..."}) or the plain path
+func uploadedPath(body []byte) string {
+	var doc struct {
+		TmpFile string `json:"tmpfile"`
+	}
+	if err := json.Unmarshal(body, &doc); err == nil {
+		return doc.TmpFile
+	}
+
+	return strings.TrimSpace(string(body))
+}
+
+// fileChecksum returns the SHA1 checksum of a local file, as Upload computes it
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha1.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,103 @@
+package opennebula
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClientUpload(t *testing.T) {
+	contents := []byte("not really a qcow2 image")
+	var received []byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "oneadmin" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("Expected a multipart upload with a file field: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		if received, err = ioutil.ReadAll(file); err != nil {
+			t.Errorf("Could not read the uploaded file: %s", err)
+		}
+		w.Write([]byte(`{"tmpfile": "/var/tmp/upload-` + header.Filename + `"}`))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "opennebula-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "disk.qcow2")
+	if err = ioutil.WriteFile(path, contents, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &Client{Username: "oneadmin", Password: "secret", UploadEndpoint: server.URL}
+	tmpPath, checksum, err := client.Upload(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if tmpPath != "/var/tmp/upload-disk.qcow2" {
+		t.Errorf("Expected the temporary path from the response, got %q", tmpPath)
+	}
+	if string(received) != string(contents) {
+		t.Errorf("Expected the server to receive %q, got %q", contents, received)
+	}
+	sum := sha1.Sum(contents)
+	if checksum != hex.EncodeToString(sum[:]) {
+		t.Errorf("Expected checksum %s, got %s", hex.EncodeToString(sum[:]), checksum)
+	}
+
+	client.Password = "wrong"
+	if _, _, err = client.Upload(path); err == nil {
+		t.Errorf("Expected the upload to fail when the endpoint rejects the credentials")
+	}
+}
+
+func TestUploadedPath(t *testing.T) {
+	if p := uploadedPath([]byte(`{"tmpfile":"/var/tmp/abc"}`)); p != "/var/tmp/abc" {
+		t.Errorf("Expected the path from the JSON response, got %q", p)
+	}
+	if p := uploadedPath([]byte("/var/tmp/abc\n")); p != "/var/tmp/abc" {
+		t.Errorf("Expected the plain path, got %q", p)
+	}
+}
+
+func TestFileChecksum(t *testing.T) {
+	contents := []byte("not really a qcow2 image")
+
+	dir, err := ioutil.TempDir("", "opennebula-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "disk.qcow2")
+	if err = ioutil.WriteFile(path, contents, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha1.Sum(contents)
+	if checksum, err := fileChecksum(path); err != nil || checksum != hex.EncodeToString(sum[:]) {
+		t.Errorf("Expected checksum %s, got %s (err: %v)", hex.EncodeToString(sum[:]), checksum, err)
+	}
+	if _, err = fileChecksum(filepath.Join(dir, "missing.qcow2")); err == nil {
+		t.Errorf("Expected a missing file to have no checksum")
+	}
+}
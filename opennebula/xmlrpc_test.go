@@ -0,0 +1,121 @@
+package opennebula
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type xmlRpcCall struct {
+	Method string
+	Params []string
+}
+
+// xmlRpcStandIn is a minimal stand-in for oned. Handlers receive the parameters
+// of a call (without the session) as strings and return the value of a
+// successful response, or an error. An *Error is answered with its own code.
+type xmlRpcStandIn struct {
+	*httptest.Server
+	Calls []xmlRpcCall
+}
+
+func newXmlRpcStandIn(t *testing.T, handlers map[string]func(params []string) (interface{}, error)) *xmlRpcStandIn {
+	standIn := &xmlRpcStandIn{}
+
+	standIn.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var call struct {
+			Method string `xml:"methodName"`
+			Params []struct {
+				Value string `xml:",innerxml"`
+			} `xml:"params>param>value"`
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := xml.Unmarshal(body, &call); err != nil {
+			t.Fatalf("Could not parse XML-RPC call %s: %s", body, err)
+		}
+
+		params := make([]string, 0)
+		for _, p := range call.Params[1:] {
+			// values are either typed (<int>1</int>) or plain strings
+			var value struct {
+				Typed struct {
+					Text string `xml:",chardata"`
+				} `xml:",any"`
+				Text string `xml:",chardata"`
+			}
+			xml.Unmarshal([]byte("<value>"+p.Value+"</value>"), &value)
+			params = append(params, value.Typed.Text+strings.TrimSpace(value.Text))
+		}
+		standIn.Calls = append(standIn.Calls, xmlRpcCall{Method: call.Method, Params: params})
+
+		handler, ok := handlers[call.Method]
+		if !ok {
+			t.Errorf("Unexpected call to %s", call.Method)
+			writeXmlRpcResponse(w, false, "not implemented", ErrXmlRpcApi)
+			return
+		}
+
+		result, err := handler(params)
+		if oneErr, ok := err.(*Error); ok {
+			writeXmlRpcResponse(w, false, oneErr.Message, oneErr.Code)
+			return
+		} else if err != nil {
+			writeXmlRpcResponse(w, false, err.Error(), ErrAction)
+			return
+		}
+		writeXmlRpcResponse(w, true, result, 0)
+	}))
+
+	return standIn
+}
+
+func writeXmlRpcResponse(w http.ResponseWriter, success bool, result interface{}, code int) {
+	value := ""
+	switch r := result.(type) {
+	case int:
+		value = fmt.Sprintf("<i4>%d</i4>", r)
+	default:
+		var escaped strings.Builder
+		xml.EscapeText(&escaped, []byte(fmt.Sprintf("%s", r)))
+		value = "<string>" + escaped.String() + "</string>"
+	}
+
+	ok := "0"
+	if success {
+		ok = "1"
+	}
+
+	fmt.Fprintf(w, `<?xml version="1.0"?>
+<methodResponse><params><param><value><array><data>
+<value><boolean>%s</boolean></value>
+<value>%s</value>
+<value><i4>%d</i4></value>
+</data></array></value></param></params></methodResponse>`, ok, value, code)
+}
+
+// newTestClient starts an XML-RPC stand-in with the given handlers and returns a client of it.
+// The caller closes the stand-in
+func newTestClient(t *testing.T, handlers map[string]func(params []string) (interface{}, error)) (*Client, *xmlRpcStandIn) {
+	standIn := newXmlRpcStandIn(t, handlers)
+
+	client, err := NewClient(standIn.URL, "oneadmin", "secret")
+	if err != nil {
+		standIn.Close()
+		t.Fatal(err)
+	}
+
+	return client, standIn
+}
+
+// checkCalls fails the test unless the stand-in received exactly the expected calls
+func (s *xmlRpcStandIn) checkCalls(t *testing.T, expected []xmlRpcCall) {
+	if !reflect.DeepEqual(s.Calls, expected) {
+		t.Errorf("Expected calls %v, got %v", expected, s.Calls)
+	}
+}